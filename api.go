@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RunStatus is the body returned by every successful API request. Stage
+// outputs are included for completed stages whose output encodes as JSON.
+type RunStatus struct {
+	RunID   string                     `json:"run_id"`
+	Running bool                       `json:"running"`
+	Paused  bool                       `json:"paused"`
+	Stages  map[string]StageCheckpoint `json:"stages"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiHandler struct {
+	pipeline *Pipeline
+}
+
+// NewAPIHandler returns an http.Handler that exposes the control plane of p:
+//
+//	GET  /status                 current run status
+//	POST /run                    start Execute in the background, after validating
+//	                             the pipeline
+//	POST /stop                   Stop
+//	POST /pause                  Pause, interrupting running stages with ?interrupt=true
+//	POST /resume                 Resume
+//	POST /restart-failed         RestartFailedStages
+//	POST /stages/{name}/restart  RestartStage
+//	POST /stages/{name}/cancel   CancelStage, with an optional ?reason=
+//	POST /reset                  Reset, which also lets a stopped pipeline run again
+//
+// Mount it under a prefix with http.StripPrefix.
+func NewAPIHandler(p *Pipeline) http.Handler {
+	return &apiHandler{pipeline: p}
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	method := http.MethodPost
+	var action func() (int, error)
+	switch path {
+	case "status":
+		method, action = http.MethodGet, h.status
+	case "run":
+		action = h.run
+	case "stop":
+		action = h.stop
+	case "pause":
+		action = func() (int, error) { return h.pause(r.URL.Query().Get("interrupt")) }
+	case "resume":
+		action = h.resume
+	case "restart-failed":
+		action = h.restartFailed
+	case "reset":
+		action = h.reset
+	default:
+		if name, ok := stageAction(path, "restart"); ok {
+			action = func() (int, error) { return h.restartStage(name) }
+		} else if name, ok := stageAction(path, "cancel"); ok {
+			action = func() (int, error) { return h.cancelStage(name, r.URL.Query().Get("reason")) }
+		}
+	}
+
+	if action == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("unknown endpoint /%s", path)})
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: fmt.Sprintf("/%s only supports %s", path, method)})
+		return
+	}
+
+	code, err := action()
+	if err != nil {
+		writeJSON(w, code, apiError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, code, h.runStatus())
+}
+
+// stageAction splits a path of the form stages/{name}/{action}.
+func stageAction(path, action string) (string, bool) {
+	name, ok := strings.CutPrefix(path, "stages/")
+	if !ok {
+		return "", false
+	}
+	name, ok = strings.CutSuffix(name, "/"+action)
+	return name, ok && name != ""
+}
+
+func (h *apiHandler) status() (int, error) {
+	return http.StatusOK, nil
+}
+
+// run starts the pipeline synchronously, so that a pipeline that cannot run
+// is reported to the caller, and lets it execute in the background.
+func (h *apiHandler) run() (int, error) {
+	err := h.pipeline.start()
+	switch {
+	case errors.Is(err, ErrAlreadyExecuting), errors.Is(err, ErrStopped):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusUnprocessableEntity, err
+	}
+	go h.pipeline.execute(context.Background())
+	return http.StatusAccepted, nil
+}
+
+func (h *apiHandler) stop() (int, error) {
+	h.pipeline.Stop()
+	return http.StatusAccepted, nil
+}
+
+func (h *apiHandler) pause(interrupt string) (int, error) {
+	switch interrupt {
+	case "", "false":
+		h.pipeline.Pause(false)
+	case "true":
+		h.pipeline.Pause(true)
+	default:
+		return http.StatusBadRequest, fmt.Errorf("invalid interrupt value %q", interrupt)
+	}
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) resume() (int, error) {
+	h.pipeline.Resume()
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) restartFailed() (int, error) {
+	if err := h.pipeline.RestartFailedStages(); err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) restartStage(name string) (int, error) {
+	if _, exists := h.pipeline.GetStageResult(name); !exists {
+		return http.StatusNotFound, fmt.Errorf("stage %s not found", name)
+	}
+	if err := h.pipeline.RestartStage(name); err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) cancelStage(name, reason string) (int, error) {
+	if _, exists := h.pipeline.GetStageResult(name); !exists {
+		return http.StatusNotFound, fmt.Errorf("stage %s not found", name)
+	}
+	var cause error
+	if reason != "" {
+		cause = errors.New(reason)
+	}
+	if err := h.pipeline.CancelStage(name, cause); err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) reset() (int, error) {
+	if err := h.pipeline.Reset(); err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+func (h *apiHandler) runStatus() RunStatus {
+	results := h.pipeline.snapshotResults()
+	status := RunStatus{
+		RunID:   h.pipeline.RunID(),
+		Running: h.pipeline.IsRunning(),
+		Paused:  h.pipeline.IsPaused(),
+		Stages:  make(map[string]StageCheckpoint, len(results)),
+	}
+	for name, result := range results {
+		status.Stages[name], _ = newStageCheckpoint(result)
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
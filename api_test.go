@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pipeline/client"
+)
+
+func newTestAPI(t *testing.T, p *Pipeline) *client.Client {
+	t.Helper()
+	server := httptest.NewServer(NewAPIHandler(p))
+	t.Cleanup(server.Close)
+	return client.New(server.URL, server.Client())
+}
+
+func assertAPIError(t *testing.T, err error, code int) {
+	t.Helper()
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != code {
+		t.Fatalf("got error %v, want HTTP %d", err, code)
+	}
+}
+
+func waitIdle(t *testing.T, p *Pipeline) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.IsRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("pipeline is still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAPIRunRejectsInvalidPipeline(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{}, newTestStage("a", []string{"missing"}, sleepStage("a", 0)))
+	c := newTestAPI(t, p)
+
+	_, err := c.Run(context.Background())
+	assertAPIError(t, err, http.StatusUnprocessableEntity)
+	if p.IsRunning() {
+		t.Error("invalid pipeline is running")
+	}
+}
+
+func TestAPIRunStopAndReset(t *testing.T) {
+	gate := make(chan struct{})
+	p := newTestPipeline(t, PipelineConfig{}, newTestStage("a", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		select {
+		case <-gate:
+			return "a", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	c := newTestAPI(t, p)
+	ctx := context.Background()
+
+	if _, err := c.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	_, err := c.Run(ctx)
+	assertAPIError(t, err, http.StatusConflict)
+
+	if _, err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitIdle(t, p)
+	_, err = c.Run(ctx)
+	assertAPIError(t, err, http.StatusConflict)
+
+	if _, err := c.Reset(ctx); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	close(gate)
+	if _, err := c.Run(ctx); err != nil {
+		t.Fatalf("Run after Reset: %v", err)
+	}
+	waitIdle(t, p)
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if got := status.Stages["a"].Status; got != "COMPLETED" {
+		t.Errorf("stage a is %s after Reset and Run, want COMPLETED", got)
+	}
+}
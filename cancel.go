@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStageCancelled is the cause, or wraps the cause, of stages cancelled by
+// CancelStage.
+var ErrStageCancelled = errors.New("stage cancelled")
+
+// CancelStage cancels the context of the named running stage, including a
+// pending retry. A stage that returns an error because of it is marked
+// StatusCancelled, with the cause as its error, and is not retried; its
+// dependents are skipped as for a failed stage. cause may be nil.
+func (p *Pipeline) CancelStage(name string, cause error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if _, exists := p.results[name]; !exists {
+		return fmt.Errorf("stage %s not found", name)
+	}
+	cancel, running := p.inflight[name]
+	if !running {
+		return fmt.Errorf("stage %s is not running", name)
+	}
+
+	err := ErrStageCancelled
+	if cause != nil {
+		err = fmt.Errorf("%w: %w", ErrStageCancelled, cause)
+	}
+	p.log().Warn("Cancelling stage", "stage", name, "cause", err)
+	cancel(err)
+	return nil
+}
+
+// handleInterrupt reports whether the stage context was cancelled by Pause or
+// CancelStage rather than by the run, and if so records the stage outcome.
+func (p *Pipeline) handleInterrupt(stageCtx context.Context, name string, err error) bool {
+	cause := context.Cause(stageCtx)
+	switch {
+	case errors.Is(cause, ErrPaused):
+		p.requeueInterrupted(name, err)
+	case errors.Is(cause, ErrStageCancelled):
+		p.markCancelled(name, cause)
+	default:
+		return false
+	}
+	return true
+}
+
+func (p *Pipeline) markCancelled(name string, cause error) {
+	p.mu.Lock()
+	result := p.results[name]
+	// A stage cancelled while waiting for a retry keeps the times of its
+	// last attempt.
+	if result.Status == StatusRunning {
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+	}
+	result.Status = StatusCancelled
+	result.Output = nil
+	result.Error = cause
+	p.checkpointLocked(name)
+	p.mu.Unlock()
+
+	p.log().Warn("Stage cancelled", "stage", name, "status", StatusCancelled, "cause", cause)
+	p.emitStage(EventStageCancelled, name, cause, 0)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitStage returns fn for a stage that signals started, then waits for its
+// context and returns the cause of the cancellation.
+func waitStage(started chan<- struct{}) func(ctx context.Context, input interface{}) (interface{}, error) {
+	return func(ctx context.Context, input interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, context.Cause(ctx)
+	}
+}
+
+func TestCancelStage(t *testing.T) {
+	started := make(chan struct{}, 1)
+	cancelled := newTestStage("cancelled", nil, waitStage(started))
+	cancelled.SetMaxRetries(3)
+	p := newTestPipeline(t, PipelineConfig{},
+		cancelled,
+		newTestStage("after", []string{"cancelled"}, sleepStage("after", 0)),
+		newTestStage("other", nil, sleepStage("other", 50*time.Millisecond)),
+	)
+
+	cause := errors.New("not needed")
+	go func() {
+		<-started
+		if err := p.CancelStage("cancelled", cause); err != nil {
+			t.Errorf("CancelStage: %v", err)
+		}
+	}()
+	if err := p.Execute(); err == nil {
+		t.Fatal("Execute succeeded with a cancelled stage")
+	}
+
+	assertStatuses(t, p, map[string]StageStatus{
+		"cancelled": StatusCancelled, "after": StatusSkipped, "other": StatusCompleted,
+	})
+	result, _ := p.GetStageResult("cancelled")
+	if result.Attempts != 1 {
+		t.Errorf("cancelled stage made %d attempts, want 1", result.Attempts)
+	}
+	if !errors.Is(result.Error, ErrStageCancelled) || !errors.Is(result.Error, cause) {
+		t.Errorf("cancelled stage error is %v, want ErrStageCancelled and the cause", result.Error)
+	}
+
+	// A cancelled stage runs again after RestartFailedStages.
+	cancelled.fn = sleepStage("cancelled", 0)
+	if err := p.RestartFailedStages(); err != nil {
+		t.Fatalf("RestartFailedStages: %v", err)
+	}
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute after restart: %v", err)
+	}
+	assertStatuses(t, p, map[string]StageStatus{"cancelled": StatusCompleted, "after": StatusCompleted})
+}
+
+func TestCancelStageRejectsStagesNotRunning(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{}, newTestStage("a", nil, sleepStage("a", 0)))
+
+	if err := p.CancelStage("missing", nil); err == nil {
+		t.Error("CancelStage of an unknown stage succeeded")
+	}
+	if err := p.CancelStage("a", nil); err == nil {
+		t.Error("CancelStage of a pending stage succeeded")
+	}
+}
+
+func TestCancelStageDuringRetryWait(t *testing.T) {
+	failed := make(chan struct{}, 1)
+	stage := newTestStage("retrying", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		failed <- struct{}{}
+		return nil, errors.New("boom")
+	})
+	stage.SetMaxRetries(3).SetRetryDelay(time.Minute)
+	p := newTestPipeline(t, PipelineConfig{}, stage)
+
+	go func() {
+		<-failed
+		for p.CancelStage("retrying", nil) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	p.Execute()
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("cancelling took %s, the retry wait was not interrupted", elapsed)
+	}
+	assertStatuses(t, p, map[string]StageStatus{"retrying": StatusCancelled})
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRunNotFound = errors.New("run not found")
+
+type StageCheckpoint struct {
+	Status     StageStatus     `json:"status"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Attempts   int             `json:"attempts"`
+	StartTime  time.Time       `json:"start_time"`
+	EndTime    time.Time       `json:"end_time"`
+	Duration   time.Duration   `json:"duration"`
+	SkipReason string          `json:"skip_reason,omitempty"`
+
+	SubStages map[string]StageCheckpoint `json:"sub_stages,omitempty"`
+}
+
+// CheckpointStore persists the state of every stage of a run so that the run
+// can be resumed by another process.
+type CheckpointStore interface {
+	SaveStage(runID, stage string, checkpoint StageCheckpoint) error
+	Load(runID string) (map[string]StageCheckpoint, error)
+}
+
+type runCheckpoint struct {
+	RunID     string                     `json:"run_id"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+	Stages    map[string]StageCheckpoint `json:"stages"`
+}
+
+// FileCheckpointStore keeps one JSON file per run in a directory. Files are
+// replaced atomically, so a crash never leaves a half-written checkpoint.
+type FileCheckpointStore struct {
+	dir string
+	mu  sync.Mutex
+	// current is the run last saved, kept so that saving a stage does not
+	// read the file back. Other runs are read from disk when loaded.
+	current *runCheckpoint
+}
+
+func NewFileCheckpointStore(dir string) *FileCheckpointStore {
+	return &FileCheckpointStore{dir: dir}
+}
+
+func (s *FileCheckpointStore) path(runID string) string {
+	return filepath.Join(s.dir, runID+".json")
+}
+
+func (s *FileCheckpointStore) SaveStage(runID, stage string, checkpoint StageCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, err := s.load(runID)
+	if errors.Is(err, ErrRunNotFound) {
+		run = &runCheckpoint{RunID: runID, Stages: make(map[string]StageCheckpoint)}
+	} else if err != nil {
+		return err
+	}
+	s.current = run
+
+	run.Stages[stage] = checkpoint
+	run.UpdatedAt = time.Now()
+	return s.write(run)
+}
+
+func (s *FileCheckpointStore) Load(runID string) (map[string]StageCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, err := s.load(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[string]StageCheckpoint, len(run.Stages))
+	for name, checkpoint := range run.Stages {
+		stages[name] = checkpoint
+	}
+	return stages, nil
+}
+
+// StageDurations returns, for every stage completed in one of the last runs
+// saved in the store, its duration in the most recent of those runs.
+func (s *FileCheckpointStore) StageDurations(runs int) (map[string]time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type savedRun struct {
+		id      string
+		modTime time.Time
+	}
+	var saved []savedRun
+	for _, entry := range entries {
+		runID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		saved = append(saved, savedRun{id: runID, modTime: info.ModTime()})
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].modTime.After(saved[j].modTime) })
+	if len(saved) > runs {
+		saved = saved[:runs]
+	}
+
+	durations := make(map[string]time.Duration)
+	for _, r := range saved {
+		run, err := s.load(r.id)
+		if err != nil {
+			return nil, err
+		}
+		for name, checkpoint := range run.Stages {
+			if _, seen := durations[name]; !seen && checkpoint.Status == StatusCompleted {
+				durations[name] = checkpoint.Duration
+			}
+		}
+	}
+	return durations, nil
+}
+
+func (s *FileCheckpointStore) load(runID string) (*runCheckpoint, error) {
+	if strings.ContainsAny(runID, `/\`) || runID == "" || runID == "." || runID == ".." {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	if s.current != nil && s.current.RunID == runID {
+		return s.current, nil
+	}
+
+	data, err := os.ReadFile(s.path(runID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var run runCheckpoint
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint of run %s: %w", runID, err)
+	}
+	if run.Stages == nil {
+		run.Stages = make(map[string]StageCheckpoint)
+	}
+	return &run, nil
+}
+
+func (s *FileCheckpointStore) write(run *runCheckpoint) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, run.RunID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(run.RunID))
+}
+
+func newRunID() string {
+	return fmt.Sprintf("%s-%06x", time.Now().Format("20060102-150405"), rand.Intn(1<<24))
+}
+
+type outputDecoder interface {
+	decodeOutput(data []byte) (interface{}, error)
+}
+
+func decodeStageOutput(stage Stage, data []byte) (interface{}, error) {
+	if decoder, ok := stageAs[outputDecoder](stage); ok {
+		return decoder.decodeOutput(data)
+	}
+	var output interface{}
+	err := json.Unmarshal(data, &output)
+	return output, err
+}
+
+// checkpointLocked saves the current state of the named stage. The caller
+// must hold p.mu.
+func (p *Pipeline) checkpointLocked(name string) {
+	if p.checkpoints == nil {
+		return
+	}
+
+	result := p.results[name]
+	if sub, ok := stageAs[subPipelineStage](p.stages[name]); ok {
+		snapshot := *result
+		snapshot.SubStages = sub.Child().snapshotResults()
+		result = &snapshot
+	}
+	checkpoint, err := newStageCheckpoint(result)
+	if err != nil {
+		p.log().Warn("Stage output cannot be checkpointed, it will run again on resume", "stage", name, "error", err)
+	}
+
+	p.checkpointWriter.enqueue(p, pendingCheckpoint{
+		store:      p.checkpoints,
+		runID:      p.RunID(),
+		stage:      name,
+		checkpoint: checkpoint,
+	})
+}
+
+type pendingCheckpoint struct {
+	store      CheckpointStore
+	runID      string
+	stage      string
+	checkpoint StageCheckpoint
+}
+
+// checkpointWriter saves checkpoints in the order they were taken, from a
+// goroutine that runs while there are checkpoints to save, so that stage
+// transitions do not wait for the store while holding the pipeline lock.
+type checkpointWriter struct {
+	mu      sync.Mutex
+	idle    *sync.Cond
+	queue   []pendingCheckpoint
+	writing bool
+}
+
+func (w *checkpointWriter) enqueue(p *Pipeline, checkpoint pendingCheckpoint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.queue = append(w.queue, checkpoint)
+	if !w.writing {
+		w.writing = true
+		go w.drain(p)
+	}
+}
+
+func (w *checkpointWriter) drain(p *Pipeline) {
+	for {
+		w.mu.Lock()
+		batch := w.queue
+		w.queue = nil
+		if len(batch) == 0 {
+			w.writing = false
+			w.idleCond().Broadcast()
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+
+		// Only the last state of a stage in the batch needs saving.
+		last := make(map[[2]string]int, len(batch))
+		for i, c := range batch {
+			last[[2]string{c.runID, c.stage}] = i
+		}
+		for i, c := range batch {
+			if last[[2]string{c.runID, c.stage}] != i {
+				continue
+			}
+			if err := c.store.SaveStage(c.runID, c.stage, c.checkpoint); err != nil {
+				p.log().Error("Failed to checkpoint stage", "stage", c.stage, "status", c.checkpoint.Status, "error", err)
+			}
+		}
+	}
+}
+
+// wait returns once every checkpoint taken so far has been saved.
+func (w *checkpointWriter) wait() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.writing {
+		w.idleCond().Wait()
+	}
+}
+
+// idleCond returns the condition signalled when the writer goroutine exits.
+// The caller must hold w.mu.
+func (w *checkpointWriter) idleCond() *sync.Cond {
+	if w.idle == nil {
+		w.idle = sync.NewCond(&w.mu)
+	}
+	return w.idle
+}
+
+// newStageCheckpoint converts result to its serialisable form. The output of
+// a completed stage is included unless it cannot be encoded as JSON, in which
+// case the encoding error is returned alongside the checkpoint.
+func newStageCheckpoint(result *StageResult) (StageCheckpoint, error) {
+	checkpoint := StageCheckpoint{
+		Status:     result.Status,
+		Attempts:   result.Attempts,
+		StartTime:  result.StartTime,
+		EndTime:    result.EndTime,
+		Duration:   result.Duration,
+		SkipReason: result.SkipReason,
+	}
+	if result.Error != nil {
+		checkpoint.Error = result.Error.Error()
+	}
+	if result.SubStages != nil {
+		checkpoint.SubStages = make(map[string]StageCheckpoint, len(result.SubStages))
+		for name, sub := range result.SubStages {
+			checkpoint.SubStages[name], _ = newStageCheckpoint(sub)
+		}
+	}
+	if result.Status != StatusCompleted {
+		return checkpoint, nil
+	}
+
+	output, err := json.Marshal(result.Output)
+	if err != nil {
+		return checkpoint, err
+	}
+	checkpoint.Output = output
+	return checkpoint, nil
+}
+
+func (c StageCheckpoint) Result() *StageResult {
+	result := &StageResult{
+		Status:     c.Status,
+		Attempts:   c.Attempts,
+		StartTime:  c.StartTime,
+		EndTime:    c.EndTime,
+		Duration:   c.Duration,
+		SkipReason: c.SkipReason,
+	}
+	if c.Error != "" {
+		result.Error = errors.New(c.Error)
+	}
+	if c.SubStages != nil {
+		result.SubStages = make(map[string]*StageResult, len(c.SubStages))
+		for name, sub := range c.SubStages {
+			result.SubStages[name] = sub.Result()
+		}
+	}
+	return result
+}
+
+// ResumeRun reloads the completed stages of a checkpointed run and executes
+// the rest of the pipeline under the same run ID. Stages that had not
+// completed, or whose dependencies had not, run again.
+func (p *Pipeline) ResumeRun(runID string) error {
+	if err := p.RestoreRun(runID); err != nil {
+		return err
+	}
+	return p.Execute()
+}
+
+// RestoreRun loads the completed stages of a checkpointed run, as ResumeRun
+// does, without executing the pipeline. It fails while the pipeline is
+// executing.
+func (p *Pipeline) RestoreRun(runID string) error {
+	if p.IsRunning() {
+		return fmt.Errorf("cannot restore run %s while the pipeline is executing", runID)
+	}
+
+	p.mu.RLock()
+	store := p.checkpoints
+	p.mu.RUnlock()
+	if store == nil {
+		return fmt.Errorf("cannot restore run %s: no checkpoint store configured", runID)
+	}
+	p.checkpointWriter.wait()
+
+	checkpoints, err := store.Load(runID)
+	if err != nil {
+		return fmt.Errorf("cannot restore run %s: %w", runID, err)
+	}
+
+	p.mu.Lock()
+	// Stages added by Expand are not rebuilt, and a restored stage that
+	// expanded does not run again to add them, so its dependents would run
+	// without them.
+	var unknown []string
+	for name := range checkpoints {
+		if _, exists := p.stages[name]; !exists {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		p.mu.Unlock()
+		sort.Strings(unknown)
+		return fmt.Errorf("cannot restore run %s: checkpoint has stages the pipeline does not know: %s", runID, strings.Join(unknown, ", "))
+	}
+
+	p.runID.Store(runID)
+	for name, result := range p.results {
+		resetResult(result)
+
+		checkpoint, ok := checkpoints[name]
+		if ok && checkpoint.Status == StatusCompleted {
+			p.durations[name] = checkpoint.Duration
+		}
+		if !ok || checkpoint.Status != StatusCompleted || len(checkpoint.Output) == 0 {
+			continue
+		}
+		output, err := decodeStageOutput(p.stages[name], checkpoint.Output)
+		if err != nil {
+			p.log().Warn("Cannot restore stage output, it will run again", "stage", name, "error", err)
+			continue
+		}
+
+		result.Status = StatusCompleted
+		result.Output = output
+		result.Attempts = checkpoint.Attempts
+		result.StartTime = checkpoint.StartTime
+		result.EndTime = checkpoint.EndTime
+		result.Duration = checkpoint.Duration
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for name := range p.stages {
+			result := p.results[name]
+			if result.Status != StatusCompleted {
+				continue
+			}
+			for _, dep := range p.deps(name) {
+				if p.results[dep].Status != StatusCompleted {
+					resetResult(result)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	restored := 0
+	for name, result := range p.results {
+		if result.Status == StatusCompleted {
+			restored++
+		}
+		p.checkpointLocked(name)
+	}
+	p.mu.Unlock()
+	// Like Execute, return only once the restored state is saved.
+	p.checkpointWriter.wait()
+
+	p.log().Info("Restored run", "completed_stages", restored)
+	return nil
+}
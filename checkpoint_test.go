@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type checkpointReport struct {
+	Records int    `json:"records"`
+	Source  string `json:"source"`
+}
+
+func TestResumeRunInNewPipeline(t *testing.T) {
+	dir := t.TempDir()
+	var extractRuns, loadRuns atomic.Int32
+	failLoad := true
+	newPipeline := func() *Pipeline {
+		p := newTestPipeline(t, PipelineConfig{},
+			newTypedTestStage("extract", nil, func(struct{}) checkpointReport {
+				extractRuns.Add(1)
+				return checkpointReport{Records: 3, Source: "db"}
+			}),
+			newTestStage("load", []string{"extract"}, func(ctx context.Context, input interface{}) (interface{}, error) {
+				loadRuns.Add(1)
+				if failLoad {
+					return nil, errors.New("boom")
+				}
+				report, ok := input.(checkpointReport)
+				if !ok {
+					return nil, Permanent(errors.New("extract output was not decoded into its type"))
+				}
+				return report.Records, nil
+			}),
+		)
+		// A new store, as in a new process, reads the run from disk.
+		p.SetCheckpointStore(NewFileCheckpointStore(dir))
+		return p
+	}
+
+	p := newPipeline()
+	if err := p.Execute(); err == nil {
+		t.Fatal("Execute succeeded with a failing stage")
+	}
+
+	failLoad = false
+	resumed := newPipeline()
+	if err := resumed.ResumeRun(p.RunID()); err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	if resumed.RunID() != p.RunID() {
+		t.Errorf("resumed run ID is %s, want %s", resumed.RunID(), p.RunID())
+	}
+	if extractRuns.Load() != 1 || loadRuns.Load() != 2 {
+		t.Errorf("extract ran %d times and load %d times, want 1 and 2", extractRuns.Load(), loadRuns.Load())
+	}
+	result, _ := resumed.GetStageResult("load")
+	if result.Output != 3 {
+		t.Errorf("load output is %v, want 3", result.Output)
+	}
+
+	checkpoints, err := NewFileCheckpointStore(dir).Load(p.RunID())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if checkpoints["load"].Status != StatusCompleted || string(checkpoints["load"].Output) != "3" {
+		t.Errorf("checkpoint of load is %+v", checkpoints["load"])
+	}
+}
+
+func TestRestoreRunResetsStagesWithIncompleteDependencies(t *testing.T) {
+	store := NewFileCheckpointStore(t.TempDir())
+	completed := func(output string) StageCheckpoint {
+		return StageCheckpoint{Status: StatusCompleted, Output: json.RawMessage(output), Attempts: 1}
+	}
+	store.SaveStage("run", "a", completed(`"a"`))
+	store.SaveStage("run", "b", StageCheckpoint{Status: StatusFailed, Error: "boom", Attempts: 1})
+	store.SaveStage("run", "c", completed(`"c"`))
+	store.SaveStage("run", "d", completed(`"d"`))
+
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("a", nil, sleepStage("a", 0)),
+		newTestStage("b", []string{"a"}, sleepStage("b", 0)),
+		newTestStage("c", []string{"b"}, sleepStage("c", 0)),
+		newTestStage("d", []string{"c"}, sleepStage("d", 0)),
+	)
+	p.SetCheckpointStore(store)
+
+	if err := p.RestoreRun("run"); err != nil {
+		t.Fatalf("RestoreRun: %v", err)
+	}
+	assertStatuses(t, p, map[string]StageStatus{
+		"a": StatusCompleted, "b": StatusPending, "c": StatusPending, "d": StatusPending,
+	})
+}
+
+func TestRestoreRunWhileExecuting(t *testing.T) {
+	store := NewFileCheckpointStore(t.TempDir())
+	store.SaveStage("old", "a", StageCheckpoint{Status: StatusCompleted, Output: json.RawMessage(`"a"`)})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("a", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return "a", nil
+		}),
+	)
+	p.SetCheckpointStore(store)
+	runID := p.RunID()
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Execute() }()
+	<-started
+
+	if err := p.ResumeRun("old"); err == nil {
+		t.Error("ResumeRun succeeded while the pipeline was executing")
+	}
+	if p.RunID() != runID {
+		t.Errorf("run ID changed to %s", p.RunID())
+	}
+	assertStatuses(t, p, map[string]StageStatus{"a": StatusRunning})
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	checkpoints, err := store.Load(runID)
+	if err != nil || checkpoints["a"].Status != StatusCompleted {
+		t.Errorf("checkpoint of the live run is %+v, %v", checkpoints["a"], err)
+	}
+}
+
+func TestExecuteSavesCheckpointsBeforeReturning(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPipeline(t, PipelineConfig{MaxConcurrency: 4})
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		p.AddStage(newTestStage(name, nil, sleepStage(name, time.Millisecond)))
+	}
+	p.SetCheckpointStore(NewFileCheckpointStore(dir))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	checkpoints, err := NewFileCheckpointStore(dir).Load(p.RunID())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for name, checkpoint := range checkpoints {
+		if checkpoint.Status != StatusCompleted {
+			t.Errorf("checkpoint of %s is %s when Execute returned", name, checkpoint.Status)
+		}
+	}
+	if len(checkpoints) != 8 {
+		t.Errorf("%d stages checkpointed, want 8", len(checkpoints))
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const cliUsage = `Usage: pipeline <command> [flags]
+
+Commands:
+  run                        execute a pipeline definition
+  validate                   check a pipeline definition without running it
+  status <runID>             print the stage results of a run
+  restart <runID> -stage X   restart stage X and its dependents, then execute the run
+  graph [runID]              print the stage graph as DOT or Mermaid, coloured by
+                             the stage results of the run if one is given
+
+Without a command, the built-in demo pipeline is run.
+Run 'pipeline <command> -h' for the flags of a command.
+`
+
+type cli struct {
+	registry *StageRegistry
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// runCLI runs the command in args and returns the process exit code: 0 on
+// success, 1 if the pipeline or its definition failed, 2 on usage errors.
+func runCLI(args []string, registry *StageRegistry, stdout, stderr io.Writer) int {
+	c := &cli{registry: registry, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return c.run(args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "status":
+		return c.status(args[1:])
+	case "restart":
+		return c.restart(args[1:])
+	case "graph":
+		return c.graph(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "pipeline: unknown command %q\n\n%s", args[0], cliUsage)
+		return exitUsage
+	}
+}
+
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("pipeline "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parseFlags parses args with fs, allowing flags after positional arguments,
+// and returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (c *cli) usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(c.stderr, "%s: %s\n", fs.Name(), fmt.Sprintf(format, args...))
+	fs.Usage()
+	return exitUsage
+}
+
+func flagError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+func (c *cli) load(file string) (*Pipeline, error) {
+	def, err := LoadDefinition(file)
+	if err != nil {
+		return nil, err
+	}
+	return def.Build(c.registry, slog.New(slog.NewTextHandler(c.stderr, nil)))
+}
+
+func (c *cli) fail(err error) int {
+	fmt.Fprintf(c.stderr, "pipeline: %v\n", err)
+	return exitFailure
+}
+
+func (c *cli) run(args []string) int {
+	fs := c.flagSet("run")
+	file := fs.String("f", "pipeline.json", "pipeline definition `file`")
+	stateDir := fs.String("state", ".pipeline-state", "`directory` holding run checkpoints")
+	resume := fs.String("resume", "", "resume the run with this `ID` instead of starting a new one")
+	listen := fs.String("listen", "", "serve the control-plane API and dashboard on this `address` while running")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(positional) > 0 {
+		return c.usageError(fs, "unexpected arguments %v", positional)
+	}
+
+	p, err := c.load(*file)
+	if err != nil {
+		return c.fail(err)
+	}
+	p.SetCheckpointStore(NewFileCheckpointStore(*stateDir))
+
+	if *listen != "" {
+		ln, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return c.fail(err)
+		}
+		dashboard := NewDashboard(p)
+		defer dashboard.Close()
+
+		mux := http.NewServeMux()
+		mux.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboard))
+		mux.Handle("/", NewAPIHandler(p))
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+
+	if *resume != "" {
+		err = p.ResumeRun(*resume)
+	} else {
+		err = p.Execute()
+	}
+	return c.finish(p, err)
+}
+
+func (c *cli) finish(p *Pipeline, err error) int {
+	writeStatus(c.stdout, p.snapshotResults())
+	fmt.Fprintf(c.stdout, "Run ID: %s\n", p.RunID())
+
+	if err != nil {
+		return c.fail(err)
+	}
+	if p.hasFailures() {
+		return c.fail(fmt.Errorf("run %s has failed stages", p.RunID()))
+	}
+	return exitOK
+}
+
+func (c *cli) validate(args []string) int {
+	fs := c.flagSet("validate")
+	file := fs.String("f", "pipeline.json", "pipeline definition `file`")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(positional) > 0 {
+		return c.usageError(fs, "unexpected arguments %v", positional)
+	}
+
+	p, err := c.load(*file)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	fmt.Fprintf(c.stdout, "%s: %d stages, definition is valid\n", *file, len(p.stageNames()))
+	return exitOK
+}
+
+func (c *cli) status(args []string) int {
+	fs := c.flagSet("status")
+	stateDir := fs.String("state", ".pipeline-state", "`directory` holding run checkpoints")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(positional) != 1 {
+		return c.usageError(fs, "expected exactly one run ID")
+	}
+	runID := positional[0]
+
+	checkpoints, err := NewFileCheckpointStore(*stateDir).Load(runID)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	results := make(map[string]*StageResult, len(checkpoints))
+	for name, checkpoint := range checkpoints {
+		results[name] = checkpoint.Result()
+	}
+	writeStatus(c.stdout, results)
+	fmt.Fprintf(c.stdout, "Run ID: %s\n", runID)
+	return exitOK
+}
+
+func (c *cli) restart(args []string) int {
+	fs := c.flagSet("restart")
+	file := fs.String("f", "pipeline.json", "pipeline definition `file`")
+	stateDir := fs.String("state", ".pipeline-state", "`directory` holding run checkpoints")
+	stage := fs.String("stage", "", "`name` of the stage to restart")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(positional) != 1 {
+		return c.usageError(fs, "expected exactly one run ID")
+	}
+	if *stage == "" {
+		return c.usageError(fs, "-stage is required")
+	}
+
+	p, err := c.load(*file)
+	if err != nil {
+		return c.fail(err)
+	}
+	p.SetCheckpointStore(NewFileCheckpointStore(*stateDir))
+
+	if err := p.RestoreRun(positional[0]); err != nil {
+		return c.fail(err)
+	}
+	if err := p.RestartStage(*stage); err != nil {
+		return c.fail(err)
+	}
+	return c.finish(p, p.Execute())
+}
+
+func (c *cli) graph(args []string) int {
+	fs := c.flagSet("graph")
+	file := fs.String("f", "pipeline.json", "pipeline definition `file`")
+	stateDir := fs.String("state", ".pipeline-state", "`directory` holding run checkpoints")
+	format := fs.String("format", "dot", "output `format`: dot or mermaid")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(positional) > 1 {
+		return c.usageError(fs, "expected at most one run ID")
+	}
+
+	write := writeDOT
+	switch *format {
+	case "dot":
+	case "mermaid":
+		write = writeMermaid
+	default:
+		return c.usageError(fs, "unknown format %q", *format)
+	}
+
+	p, err := c.load(*file)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	var results map[string]*StageResult
+	if len(positional) == 1 {
+		checkpoints, err := NewFileCheckpointStore(*stateDir).Load(positional[0])
+		if err != nil {
+			return c.fail(err)
+		}
+		results = p.snapshotResults()
+		for name, checkpoint := range checkpoints {
+			if _, ok := results[name]; ok {
+				results[name] = checkpoint.Result()
+			}
+		}
+	}
+
+	if err := write(c.stdout, p.dependencyGraph(), results); err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,159 @@
+// Package client talks to the HTTP control-plane API of a pipeline, as served
+// by the handler returned by NewAPIHandler.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StageStatus is the state of one stage. Status is one of "PENDING",
+// "RUNNING", "COMPLETED", "FAILED", "SKIPPED" or "CANCELLED".
+type StageStatus struct {
+	Status     string          `json:"status"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Attempts   int             `json:"attempts"`
+	StartTime  time.Time       `json:"start_time"`
+	EndTime    time.Time       `json:"end_time"`
+	Duration   time.Duration   `json:"duration"`
+	SkipReason string          `json:"skip_reason,omitempty"`
+
+	// SubStages holds the stages of a sub-pipeline run as this stage.
+	SubStages map[string]StageStatus `json:"sub_stages,omitempty"`
+}
+
+// RunStatus is the state of the pipeline after a request has been handled.
+type RunStatus struct {
+	RunID   string                 `json:"run_id"`
+	Running bool                   `json:"running"`
+	Paused  bool                   `json:"paused"`
+	Stages  map[string]StageStatus `json:"stages"`
+}
+
+// APIError is returned when the server rejects a request.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pipeline api: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// New returns a client for the API mounted at baseURL. If httpClient is nil,
+// http.DefaultClient is used.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// Status returns the current run status.
+func (c *Client) Status(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodGet, "/status")
+}
+
+// Run starts executing the pipeline. It returns once execution has started.
+func (c *Client) Run(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/run")
+}
+
+// Stop cancels the pipeline.
+func (c *Client) Stop(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/stop")
+}
+
+// Pause stops the pipeline from starting new stages. With interrupt, running
+// stages are cancelled and run again after Resume.
+func (c *Client) Pause(ctx context.Context, interrupt bool) (*RunStatus, error) {
+	path := "/pause"
+	if interrupt {
+		path += "?interrupt=true"
+	}
+	return c.do(ctx, http.MethodPost, path)
+}
+
+// Resume lets a paused pipeline start stages again.
+func (c *Client) Resume(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/resume")
+}
+
+// RestartFailed resets every failed stage, and the stages skipped because of
+// them, to pending.
+func (c *Client) RestartFailed(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/restart-failed")
+}
+
+// RestartStage resets the named stage and all of its dependents to pending.
+func (c *Client) RestartStage(ctx context.Context, name string) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/stages/"+url.PathEscape(name)+"/restart")
+}
+
+// CancelStage cancels the named running stage. The reason, if not empty, is
+// recorded as the cause of the cancellation.
+func (c *Client) CancelStage(ctx context.Context, name, reason string) (*RunStatus, error) {
+	path := "/stages/" + url.PathEscape(name) + "/cancel"
+	if reason != "" {
+		path += "?reason=" + url.QueryEscape(reason)
+	}
+	return c.do(ctx, http.MethodPost, path)
+}
+
+// Reset discards all stage results and starts a new run.
+func (c *Client) Reset(ctx context.Context) (*RunStatus, error) {
+	return c.do(ctx, http.MethodPost, "/reset")
+}
+
+func (c *Client) do(ctx context.Context, method, path string) (*RunStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var errBody struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &errBody) == nil && errBody.Error != "" {
+			apiErr.Message = errBody.Error
+		} else {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return nil, apiErr
+	}
+
+	var status RunStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("pipeline api: decoding response: %w", err)
+	}
+	return &status, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+const dashboardRefreshInterval = 5 * time.Second
+
+// AttemptRecord is one attempt of a stage as shown on the dashboard timeline.
+// End is nil while the attempt is running.
+type AttemptRecord struct {
+	Attempt    int           `json:"attempt"`
+	Start      time.Time     `json:"start"`
+	End        *time.Time    `json:"end,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	RetryDelay time.Duration `json:"retry_delay,omitempty"`
+}
+
+type dashboardStage struct {
+	Name      string          `json:"name"`
+	DependsOn []string        `json:"depends_on"`
+	Color     string          `json:"color"`
+	Result    StageCheckpoint `json:"result"`
+	Timeline  []AttemptRecord `json:"timeline"`
+}
+
+type dashboardState struct {
+	RunID   string           `json:"run_id"`
+	Running bool             `json:"running"`
+	Paused  bool             `json:"paused"`
+	Time    time.Time        `json:"time"`
+	Stages  []dashboardStage `json:"stages"`
+}
+
+type dashboardEvent struct {
+	Type       string        `json:"type"`
+	Stage      string        `json:"stage,omitempty"`
+	Attempt    int           `json:"attempt,omitempty"`
+	Time       time.Time     `json:"time"`
+	Error      string        `json:"error,omitempty"`
+	RetryDelay time.Duration `json:"retry_delay,omitempty"`
+}
+
+// Dashboard serves a web UI showing the stage graph of a pipeline with live
+// stage states, per-attempt timelines and errors. Updates are pushed to the
+// browser with server-sent events as the pipeline emits events.
+type Dashboard struct {
+	pipeline    *Pipeline
+	static      http.Handler
+	unsubscribe func()
+	done        chan struct{}
+	closeOnce   sync.Once
+
+	mu        sync.Mutex
+	runID     string
+	timelines map[string][]AttemptRecord
+	clients   map[chan Event]struct{}
+}
+
+// NewDashboard subscribes a dashboard to p. Mount it under a prefix with
+// http.StripPrefix; the prefix must end with a slash.
+func NewDashboard(p *Pipeline) *Dashboard {
+	static, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	d := &Dashboard{
+		pipeline:  p,
+		static:    http.FileServer(http.FS(static)),
+		done:      make(chan struct{}),
+		runID:     p.RunID(),
+		timelines: make(map[string][]AttemptRecord),
+		clients:   make(map[chan Event]struct{}),
+	}
+	d.unsubscribe = p.Subscribe(d)
+	return d
+}
+
+// Close unsubscribes the dashboard from the pipeline and ends open event
+// streams.
+func (d *Dashboard) Close() {
+	d.closeOnce.Do(func() {
+		d.unsubscribe()
+		close(d.done)
+	})
+}
+
+func (d *Dashboard) OnEvent(event Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if event.RunID != d.runID {
+		d.runID = event.RunID
+		d.timelines = make(map[string][]AttemptRecord)
+	}
+
+	timeline := d.timelines[event.Stage]
+	var last *AttemptRecord
+	if len(timeline) > 0 {
+		last = &timeline[len(timeline)-1]
+	}
+
+	switch event.Type {
+	case EventStageStarted:
+		if event.Attempt == 1 {
+			timeline = nil
+		}
+		timeline = append(timeline, AttemptRecord{Attempt: event.Attempt, Start: event.Result.StartTime})
+	case EventAttemptFailed, EventStageCompleted:
+		if last != nil {
+			end := event.Result.EndTime
+			last.End = &end
+			if event.Err != nil {
+				last.Error = event.Err.Error()
+			}
+		}
+	case EventRetryScheduled:
+		if last != nil {
+			last.RetryDelay = event.RetryDelay
+		}
+	case EventStageInterrupted, EventStageCancelled:
+		if last != nil && last.End == nil {
+			end := event.Time
+			last.End = &end
+			last.Error = event.Err.Error()
+		}
+	}
+	if event.Stage != "" {
+		d.timelines[event.Stage] = timeline
+	}
+
+	for client := range d.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/") {
+	case "state":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.state())
+	case "events":
+		d.serveEvents(w, r)
+	default:
+		d.static.ServeHTTP(w, r)
+	}
+}
+
+func (d *Dashboard) state() dashboardState {
+	graph := d.pipeline.dependencyGraph()
+	results := d.pipeline.snapshotResults()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := dashboardState{
+		RunID:   d.pipeline.RunID(),
+		Running: d.pipeline.IsRunning(),
+		Paused:  d.pipeline.IsPaused(),
+		Time:    time.Now(),
+		Stages:  make([]dashboardStage, 0, len(graph)),
+	}
+	for _, name := range sortedGraphNames(graph) {
+		result, ok := results[name]
+		if !ok {
+			continue
+		}
+		checkpoint, _ := newStageCheckpoint(result)
+		checkpoint.Output = nil
+		deps := graph[name]
+		if deps == nil {
+			deps = []string{}
+		}
+		state.Stages = append(state.Stages, dashboardStage{
+			Name:      name,
+			DependsOn: deps,
+			Color:     statusColors[result.Status],
+			Result:    checkpoint,
+			Timeline:  append([]AttemptRecord{}, d.timelines[name]...),
+		})
+	}
+	return state
+}
+
+// serveEvents streams the dashboard state to the browser: once on connect,
+// after every batch of pipeline events and every dashboardRefreshInterval,
+// which also picks up changes that emit no events, such as restarts.
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := make(chan Event, 64)
+	d.mu.Lock()
+	d.clients[events] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, events)
+		d.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(dashboardRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := writeSSE(w, "state", d.state()); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case event := <-events:
+			for more := true; more; {
+				if err := writeSSE(w, "pipeline", newDashboardEvent(event)); err != nil {
+					return
+				}
+				select {
+				case event = <-events:
+				default:
+					more = false
+				}
+			}
+		}
+	}
+}
+
+func newDashboardEvent(event Event) dashboardEvent {
+	e := dashboardEvent{
+		Type:       event.Type.String(),
+		Stage:      event.Stage,
+		Attempt:    event.Attempt,
+		Time:       event.Time,
+		RetryDelay: event.RetryDelay,
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	return e
+}
+
+func writeSSE(w io.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,240 @@
+"use strict";
+
+const SVG_NS = "http://www.w3.org/2000/svg";
+const NODE_WIDTH = 160;
+const NODE_HEIGHT = 44;
+const COLUMN_GAP = 60;
+const ROW_GAP = 20;
+const MAX_EVENTS = 200;
+
+function el(tag, attrs, text) {
+  const node = tag.startsWith("svg:")
+    ? document.createElementNS(SVG_NS, tag.slice(4))
+    : document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    node.setAttribute(key, value);
+  }
+  if (text !== undefined) {
+    node.textContent = text;
+  }
+  return node;
+}
+
+function formatDuration(ns) {
+  if (ns >= 1e9) return (ns / 1e9).toFixed(2) + "s";
+  if (ns >= 1e6) return (ns / 1e6).toFixed(1) + "ms";
+  return Math.round(ns / 1e3) + "µs";
+}
+
+// levels places every stage one column to the right of its deepest dependency.
+function levels(stages) {
+  const byName = new Map(stages.map((s) => [s.name, s]));
+  const level = new Map();
+  const visit = (name, seen) => {
+    if (level.has(name)) return level.get(name);
+    if (seen.has(name) || !byName.has(name)) return 0;
+    seen.add(name);
+    let l = 0;
+    for (const dep of byName.get(name).depends_on) {
+      l = Math.max(l, visit(dep, seen) + 1);
+    }
+    level.set(name, l);
+    return l;
+  };
+  stages.forEach((s) => visit(s.name, new Set()));
+  return level;
+}
+
+function renderGraph(stages) {
+  const svg = document.getElementById("graph");
+  svg.replaceChildren();
+
+  const defs = el("svg:defs");
+  const marker = el("svg:marker", {
+    id: "arrow", viewBox: "0 0 10 10", refX: 10, refY: 5,
+    markerWidth: 6, markerHeight: 6, orient: "auto",
+  });
+  marker.appendChild(el("svg:path", { d: "M 0 0 L 10 5 L 0 10 z", fill: "#888" }));
+  defs.appendChild(marker);
+  svg.appendChild(defs);
+
+  const level = levels(stages);
+  const columns = [];
+  for (const stage of stages) {
+    const l = level.get(stage.name);
+    (columns[l] = columns[l] || []).push(stage);
+  }
+
+  const position = new Map();
+  let rows = 0;
+  columns.forEach((column, c) => {
+    column.forEach((stage, r) => {
+      position.set(stage.name, {
+        x: 20 + c * (NODE_WIDTH + COLUMN_GAP),
+        y: 20 + r * (NODE_HEIGHT + ROW_GAP),
+      });
+    });
+    rows = Math.max(rows, column.length);
+  });
+  svg.setAttribute("width", 40 + columns.length * (NODE_WIDTH + COLUMN_GAP) - COLUMN_GAP);
+  svg.setAttribute("height", 40 + rows * (NODE_HEIGHT + ROW_GAP) - ROW_GAP);
+
+  for (const stage of stages) {
+    const to = position.get(stage.name);
+    for (const dep of stage.depends_on) {
+      const from = position.get(dep);
+      if (!from) continue;
+      const x1 = from.x + NODE_WIDTH, y1 = from.y + NODE_HEIGHT / 2;
+      const x2 = to.x, y2 = to.y + NODE_HEIGHT / 2;
+      const mid = (x1 + x2) / 2;
+      svg.appendChild(el("svg:path", {
+        class: "edge",
+        d: `M ${x1} ${y1} C ${mid} ${y1}, ${mid} ${y2}, ${x2} ${y2}`,
+      }));
+    }
+  }
+
+  for (const stage of stages) {
+    const { x, y } = position.get(stage.name);
+    const node = el("svg:g", { class: "node", transform: `translate(${x},${y})` });
+    node.appendChild(el("svg:title", {}, stage.result.error || stage.result.skip_reason || stage.result.status));
+    node.appendChild(el("svg:rect", { width: NODE_WIDTH, height: NODE_HEIGHT, fill: stage.color }));
+    node.appendChild(el("svg:text", { x: NODE_WIDTH / 2, y: 18 }, stage.name));
+    node.appendChild(el("svg:text", { class: "status", x: NODE_WIDTH / 2, y: 34 }, stage.result.status));
+    svg.appendChild(node);
+  }
+}
+
+function renderTimeline(stages, now) {
+  let start = Infinity;
+  let end = now;
+  for (const stage of stages) {
+    for (const attempt of stage.timeline) {
+      start = Math.min(start, Date.parse(attempt.start));
+      if (attempt.end) end = Math.max(end, Date.parse(attempt.end));
+    }
+  }
+  const span = Math.max(end - start, 1);
+
+  const body = document.querySelector("#timeline tbody");
+  body.replaceChildren();
+  for (const stage of stages) {
+    const row = el("tr");
+    row.appendChild(el("td", {}, stage.name));
+    row.appendChild(el("td", {}, stage.result.status));
+    row.appendChild(el("td", {}, String(stage.result.attempts)));
+
+    const track = el("div", { class: "track" });
+    for (const attempt of stage.timeline) {
+      const from = Date.parse(attempt.start);
+      const to = attempt.end ? Date.parse(attempt.end) : now;
+      const kind = !attempt.end ? "running" : attempt.error ? "failed" : "ok";
+      let title = `attempt ${attempt.attempt}: ${formatDuration((to - from) * 1e6)}`;
+      if (attempt.error) title += `\n${attempt.error}`;
+      if (attempt.retry_delay) title += `\nretry in ${formatDuration(attempt.retry_delay)}`;
+      const bar = el("div", {
+        class: `attempt ${kind}`,
+        title,
+        style: `left:${((from - start) / span) * 100}%;width:${((to - from) / span) * 100}%`,
+      });
+      track.appendChild(bar);
+    }
+    const cell = el("td");
+    cell.appendChild(track);
+    row.appendChild(cell);
+    body.appendChild(row);
+    renderSubStages(body, stage.name, stage.result.sub_stages, start, span, now);
+  }
+}
+
+// renderSubStages adds a row for every stage of a sub-pipeline, with one bar
+// from its start to its end.
+function renderSubStages(body, parent, subStages, start, span, now) {
+  for (const name of Object.keys(subStages || {}).sort()) {
+    const result = subStages[name];
+    const path = `${parent}/${name}`;
+    const row = el("tr", { class: "sub" });
+    row.appendChild(el("td", {}, path));
+    row.appendChild(el("td", {}, result.status));
+    row.appendChild(el("td", {}, String(result.attempts)));
+
+    const track = el("div", { class: "track" });
+    const from = Date.parse(result.start_time);
+    if (result.status !== "PENDING" && result.status !== "SKIPPED" && from > 0) {
+      const to = result.status === "RUNNING" ? now : Date.parse(result.end_time);
+      const kind = result.status === "RUNNING" ? "running" : result.error ? "failed" : "ok";
+      track.appendChild(el("div", {
+        class: `attempt ${kind}`,
+        title: result.error || formatDuration((to - from) * 1e6),
+        style: `left:${(Math.max(from - start, 0) / span) * 100}%;width:${((to - from) / span) * 100}%`,
+      }));
+    }
+    const cell = el("td");
+    cell.appendChild(track);
+    row.appendChild(cell);
+    body.appendChild(row);
+    renderSubStages(body, path, result.sub_stages, start, span, now);
+  }
+}
+
+function renderErrors(stages) {
+  const list = document.getElementById("errors");
+  list.replaceChildren();
+  for (const stage of stages) {
+    for (const attempt of stage.timeline) {
+      if (!attempt.error) continue;
+      const item = el("li");
+      item.appendChild(el("span", { class: "stage" }, `${stage.name} #${attempt.attempt}: `));
+      item.appendChild(document.createTextNode(attempt.error));
+      list.appendChild(item);
+    }
+    if (stage.result.skip_reason) {
+      const item = el("li");
+      item.appendChild(el("span", { class: "stage" }, `${stage.name} skipped: `));
+      item.appendChild(document.createTextNode(stage.result.skip_reason));
+      list.appendChild(item);
+    }
+  }
+}
+
+function renderState(state) {
+  document.getElementById("run-id").textContent = state.run_id;
+  const runState = document.getElementById("run-state");
+  runState.textContent = state.paused ? "paused" : state.running ? "running" : "idle";
+  runState.className = "badge" + (state.paused ? " paused" : state.running ? " running" : "");
+
+  renderGraph(state.stages);
+  renderTimeline(state.stages, Date.parse(state.time));
+  renderErrors(state.stages);
+}
+
+function appendEvent(event) {
+  const list = document.getElementById("events");
+  let text = `${new Date(event.time).toLocaleTimeString()} ${event.type}`;
+  if (event.stage) text += ` ${event.stage}`;
+  if (event.attempt) text += ` #${event.attempt}`;
+  if (event.retry_delay) text += ` retry in ${formatDuration(event.retry_delay)}`;
+  if (event.error) text += `: ${event.error}`;
+  list.appendChild(el("li", {}, text));
+  while (list.children.length > MAX_EVENTS) {
+    list.removeChild(list.firstChild);
+  }
+  list.scrollTop = list.scrollHeight;
+}
+
+function connect() {
+  const connection = document.getElementById("connection");
+  const source = new EventSource("events");
+  source.addEventListener("open", () => {
+    connection.textContent = "live";
+    connection.className = "badge";
+  });
+  source.addEventListener("error", () => {
+    connection.textContent = "reconnecting";
+    connection.className = "badge offline";
+  });
+  source.addEventListener("state", (e) => renderState(JSON.parse(e.data)));
+  source.addEventListener("pipeline", (e) => appendEvent(JSON.parse(e.data)));
+}
+
+connect();
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "1m30s" in
+// pipeline definition files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2s\": %s", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type PipelineDefinition struct {
+	Config ConfigDefinition  `json:"config"`
+	Stages []StageDefinition `json:"stages"`
+}
+
+type ConfigDefinition struct {
+	MaxConcurrency    int              `json:"max_concurrency"`
+	FailFast          bool             `json:"fail_fast"`
+	ContinueOnFailure bool             `json:"continue_on_failure"`
+	GlobalTimeout     Duration         `json:"global_timeout"`
+	ResourcePools     map[string]int   `json:"resource_pools,omitempty"`
+	Scheduling        SchedulingPolicy `json:"scheduling,omitempty"`
+}
+
+// StageDefinition describes one stage of a pipeline definition. Type names
+// the registered stage implementation and defaults to Name. Settings left
+// out keep the values chosen by the implementation.
+type StageDefinition struct {
+	Name        string             `json:"name"`
+	Type        string             `json:"type,omitempty"`
+	DependsOn   []string           `json:"depends_on,omitempty"`
+	MaxRetries  *int               `json:"max_retries,omitempty"`
+	RetryDelay  *Duration          `json:"retry_delay,omitempty"`
+	RetryBudget *Duration          `json:"retry_budget,omitempty"`
+	Timeout     *Duration          `json:"timeout,omitempty"`
+	Backoff     *BackoffDefinition `json:"backoff,omitempty"`
+	Resources   map[string]int     `json:"resources,omitempty"`
+	Priority    *int               `json:"priority,omitempty"`
+}
+
+type BackoffDefinition struct {
+	InitialDelay Duration `json:"initial_delay"`
+	MaxDelay     Duration `json:"max_delay"`
+	Multiplier   float64  `json:"multiplier,omitempty"`
+	Jitter       string   `json:"jitter,omitempty"`
+}
+
+func (b *BackoffDefinition) policy() (RetryPolicy, error) {
+	backoff := NewExponentialBackoff(time.Duration(b.InitialDelay), time.Duration(b.MaxDelay))
+	if b.Multiplier != 0 {
+		backoff.Multiplier = b.Multiplier
+	}
+
+	switch b.Jitter {
+	case "", "none":
+		backoff.Jitter = NoJitter
+	case "full":
+		backoff.Jitter = FullJitter
+	case "decorrelated":
+		backoff.Jitter = DecorrelatedJitter
+	default:
+		return nil, fmt.Errorf("unknown jitter %q, want none, full or decorrelated", b.Jitter)
+	}
+	return backoff, nil
+}
+
+func LoadDefinition(path string) (*PipelineDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def, err := ParseDefinition(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return def, nil
+}
+
+func ParseDefinition(data []byte) (*PipelineDefinition, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var def PipelineDefinition
+	if err := decoder.Decode(&def); err != nil {
+		return nil, fmt.Errorf("invalid pipeline definition: %w", err)
+	}
+	return &def, nil
+}
+
+func (d *PipelineDefinition) PipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		MaxConcurrency:    d.Config.MaxConcurrency,
+		FailFast:          d.Config.FailFast,
+		ContinueOnFailure: d.Config.ContinueOnFailure,
+		GlobalTimeout:     time.Duration(d.Config.GlobalTimeout),
+		ResourcePools:     d.Config.ResourcePools,
+		Scheduling:        d.Config.Scheduling,
+	}
+}
+
+// Build creates the stages of the definition from registry, applies the
+// settings of the definition to them and returns the validated pipeline.
+func (d *PipelineDefinition) Build(registry *StageRegistry, logger *slog.Logger) (*Pipeline, error) {
+	switch {
+	case d.Config.MaxConcurrency < 0:
+		return nil, fmt.Errorf("max_concurrency is %d, must not be negative", d.Config.MaxConcurrency)
+	case d.Config.GlobalTimeout < 0:
+		return nil, fmt.Errorf("global_timeout is %s, must not be negative", time.Duration(d.Config.GlobalTimeout))
+	}
+
+	p := NewPipeline(d.PipelineConfig(), logger)
+
+	for _, def := range d.Stages {
+		stage, err := def.build(registry)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.AddStage(stage); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d StageDefinition) build(registry *StageRegistry) (Stage, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("stage definition without a name")
+	}
+	if err := d.checkRanges(); err != nil {
+		return nil, fmt.Errorf("stage %s: %w", d.Name, err)
+	}
+	kind := d.Type
+	if kind == "" {
+		kind = d.Name
+	}
+
+	stage, err := registry.New(kind)
+	if err != nil {
+		return nil, fmt.Errorf("stage %s: %w", d.Name, err)
+	}
+
+	provider, ok := stageAs[baseStageProvider](stage)
+	if !ok {
+		if d.Name != stage.Name() || d.hasOverrides() {
+			return nil, fmt.Errorf("stage %s: type %s is not built on BaseStage and cannot be configured", d.Name, kind)
+		}
+		return stage, nil
+	}
+
+	base := provider.baseStage()
+	base.name = d.Name
+	if d.DependsOn != nil {
+		base.dependencies = d.DependsOn
+	}
+	if d.MaxRetries != nil {
+		base.SetMaxRetries(*d.MaxRetries)
+	}
+	if d.RetryDelay != nil {
+		base.SetRetryDelay(time.Duration(*d.RetryDelay))
+	}
+	if d.RetryBudget != nil {
+		base.SetRetryBudget(time.Duration(*d.RetryBudget))
+	}
+	if d.Timeout != nil {
+		base.SetTimeout(time.Duration(*d.Timeout))
+	}
+	if d.Backoff != nil {
+		policy, err := d.Backoff.policy()
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", d.Name, err)
+		}
+		base.SetRetryPolicy(policy)
+	}
+	for pool, amount := range d.Resources {
+		base.Require(pool, amount)
+	}
+	if d.Priority != nil {
+		base.SetPriority(*d.Priority)
+	}
+	return stage, nil
+}
+
+// checkRanges rejects settings the scheduler cannot honour, such as a
+// negative number of retries, which would leave the stage without attempts.
+func (d StageDefinition) checkRanges() error {
+	switch {
+	case d.MaxRetries != nil && *d.MaxRetries < 0:
+		return fmt.Errorf("max_retries is %d, must not be negative", *d.MaxRetries)
+	case d.RetryDelay != nil && *d.RetryDelay < 0:
+		return fmt.Errorf("retry_delay is %s, must not be negative", time.Duration(*d.RetryDelay))
+	case d.RetryBudget != nil && *d.RetryBudget < 0:
+		return fmt.Errorf("retry_budget is %s, must not be negative", time.Duration(*d.RetryBudget))
+	case d.Timeout != nil && *d.Timeout <= 0:
+		return fmt.Errorf("timeout is %s, must be positive", time.Duration(*d.Timeout))
+	case d.Backoff != nil && (d.Backoff.InitialDelay < 0 || d.Backoff.MaxDelay < 0):
+		return fmt.Errorf("backoff delays must not be negative")
+	}
+	return nil
+}
+
+func (d StageDefinition) hasOverrides() bool {
+	return d.DependsOn != nil || d.MaxRetries != nil || d.RetryDelay != nil ||
+		d.RetryBudget != nil || d.Timeout != nil || d.Backoff != nil ||
+		d.Resources != nil || d.Priority != nil
+}
+
+type baseStageProvider interface {
+	baseStage() *BaseStage
+}
+
+// StageFactory returns a new instance of a stage implementation, configured
+// with its defaults.
+type StageFactory func() Stage
+
+type StageRegistry struct {
+	factories map[string]StageFactory
+}
+
+func NewStageRegistry() *StageRegistry {
+	return &StageRegistry{factories: make(map[string]StageFactory)}
+}
+
+func (r *StageRegistry) Register(kind string, factory StageFactory) error {
+	if _, exists := r.factories[kind]; exists {
+		return fmt.Errorf("stage type %s already registered", kind)
+	}
+	r.factories[kind] = factory
+	return nil
+}
+
+func (r *StageRegistry) New(kind string) (Stage, error) {
+	factory, exists := r.factories[kind]
+	if !exists {
+		return nil, fmt.Errorf("unknown stage type %s (registered: %s)", kind, strings.Join(r.Types(), ", "))
+	}
+	return factory(), nil
+}
+
+func (r *StageRegistry) Types() []string {
+	kinds := make([]string, 0, len(r.factories))
+	for kind := range r.factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func newTestRegistry() *StageRegistry {
+	registry := NewStageRegistry()
+	registry.Register("work", func() Stage { return newTestStage("work", nil, sleepStage("work", 0)) })
+	return registry
+}
+
+func TestBuildRejectsOutOfRangeSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{"valid", `{"stages": [{"name": "a", "type": "work", "max_retries": 0, "timeout": "1s"}]}`, ""},
+		{"negative max_retries", `{"stages": [{"name": "a", "type": "work", "max_retries": -1}]}`, "max_retries is -1"},
+		{"negative retry_delay", `{"stages": [{"name": "a", "type": "work", "retry_delay": "-1s"}]}`, "retry_delay is -1s"},
+		{"negative retry_budget", `{"stages": [{"name": "a", "type": "work", "retry_budget": "-1s"}]}`, "retry_budget is -1s"},
+		{"zero timeout", `{"stages": [{"name": "a", "type": "work", "timeout": "0s"}]}`, "timeout is 0s"},
+		{"negative backoff", `{"stages": [{"name": "a", "type": "work", "backoff": {"initial_delay": "-1s"}}]}`, "backoff delays"},
+		{"negative max_concurrency", `{"config": {"max_concurrency": -1}, "stages": [{"name": "a", "type": "work"}]}`, "max_concurrency is -1"},
+		{"negative global_timeout", `{"config": {"global_timeout": "-1m"}, "stages": [{"name": "a", "type": "work"}]}`, "global_timeout is -1m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := ParseDefinition([]byte(tt.definition))
+			if err != nil {
+				t.Fatalf("ParseDefinition: %v", err)
+			}
+			_, err = def.Build(newTestRegistry(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Build: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Build returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNegativeMaxRetriesStillRunsOnce(t *testing.T) {
+	stage := newTestStage("a", nil, sleepStage("a", 0))
+	stage.SetMaxRetries(-1)
+	p := newTestPipeline(t, PipelineConfig{}, stage, newTestStage("b", []string{"a"}, sleepStage("b", 0)))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	assertStatuses(t, p, map[string]StageStatus{"a": StatusCompleted, "b": StatusCompleted})
+}
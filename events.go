@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventPipelineStarted EventType = iota
+	EventPipelineFinished
+	EventStageStarted
+	EventAttemptFailed
+	EventRetryScheduled
+	EventStageCompleted
+	EventStageFailed
+	EventStageSkipped
+	EventStageInterrupted
+	EventPipelinePaused
+	EventPipelineResumed
+	EventStageCancelled
+	EventStageAdded
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPipelineStarted:
+		return "pipeline_started"
+	case EventPipelineFinished:
+		return "pipeline_finished"
+	case EventStageStarted:
+		return "stage_started"
+	case EventAttemptFailed:
+		return "attempt_failed"
+	case EventRetryScheduled:
+		return "retry_scheduled"
+	case EventStageCompleted:
+		return "stage_completed"
+	case EventStageFailed:
+		return "stage_failed"
+	case EventStageSkipped:
+		return "stage_skipped"
+	case EventStageInterrupted:
+		return "stage_interrupted"
+	case EventPipelinePaused:
+		return "pipeline_paused"
+	case EventPipelineResumed:
+		return "pipeline_resumed"
+	case EventStageCancelled:
+		return "stage_cancelled"
+	case EventStageAdded:
+		return "stage_added"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a pipeline or stage transition. Stage events carry a
+// snapshot of the stage result taken right after the transition; Err is the
+// error of the failed attempt, or of the run for EventPipelineFinished.
+type Event struct {
+	Type       EventType
+	RunID      string
+	Stage      string
+	Attempt    int
+	Time       time.Time
+	Result     StageResult
+	Err        error
+	RetryDelay time.Duration
+}
+
+type Listener interface {
+	OnEvent(event Event)
+}
+
+type ListenerFunc func(event Event)
+
+func (f ListenerFunc) OnEvent(event Event) { f(event) }
+
+// Subscribe registers l for every event of the pipeline. Listeners are called
+// synchronously, in the goroutine of the transition; wrap slow listeners with
+// NewAsyncListener. The returned function removes the subscription.
+func (p *Pipeline) Subscribe(l Listener) (unsubscribe func()) {
+	p.listenersMu.Lock()
+	defer p.listenersMu.Unlock()
+
+	if p.listeners == nil {
+		p.listeners = make(map[int]Listener)
+	}
+	id := p.nextListenerID
+	p.nextListenerID++
+	p.listeners[id] = l
+
+	return func() {
+		p.listenersMu.Lock()
+		defer p.listenersMu.Unlock()
+		delete(p.listeners, id)
+	}
+}
+
+func (p *Pipeline) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	p.listenersMu.RLock()
+	listeners := make([]Listener, 0, len(p.listeners))
+	for _, l := range p.listeners {
+		listeners = append(listeners, l)
+	}
+	p.listenersMu.RUnlock()
+
+	for _, l := range listeners {
+		l.OnEvent(event)
+	}
+}
+
+func (p *Pipeline) emitStage(eventType EventType, name string, err error, retryDelay time.Duration) {
+	p.mu.RLock()
+	result := *p.results[name]
+	p.mu.RUnlock()
+
+	p.emit(Event{
+		Type:       eventType,
+		RunID:      p.RunID(),
+		Stage:      name,
+		Attempt:    result.Attempts,
+		Result:     result,
+		Err:        err,
+		RetryDelay: retryDelay,
+	})
+}
+
+// AsyncListener delivers events to another listener from its own goroutine
+// through a buffer. When the buffer is full, events are dropped rather than
+// blocking the pipeline.
+type AsyncListener struct {
+	listener Listener
+	events   chan Event
+	done     chan struct{}
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+}
+
+func NewAsyncListener(l Listener, buffer int) *AsyncListener {
+	a := &AsyncListener{
+		listener: l,
+		events:   make(chan Event, buffer),
+		done:     make(chan struct{}),
+	}
+	go a.loop()
+	return a
+}
+
+func (a *AsyncListener) loop() {
+	defer close(a.done)
+	for event := range a.events {
+		a.listener.OnEvent(event)
+	}
+}
+
+func (a *AsyncListener) OnEvent(event Event) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return
+	}
+	select {
+	case a.events <- event:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (a *AsyncListener) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Close stops accepting events and waits until the buffered events have been
+// delivered.
+func (a *AsyncListener) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
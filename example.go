@@ -10,6 +10,18 @@ import (
 	"time"
 )
 
+type ProcessedData struct {
+	ProcessedRecords int       `json:"processed_records"`
+	Timestamp        time.Time `json:"timestamp"`
+	Source           string    `json:"source"`
+}
+
+type ValidationReport struct {
+	ValidationPassed bool      `json:"validation_passed"`
+	InputRecords     int       `json:"input_records"`
+	ValidatedAt      time.Time `json:"validated_at"`
+}
+
 type DataProcessingStage struct {
 	*BaseStage
 }
@@ -23,17 +35,17 @@ func NewDataProcessingStage() *DataProcessingStage {
 	}
 }
 
-func (s *DataProcessingStage) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+func (s *DataProcessingStage) Execute(ctx context.Context, _ struct{}) (ProcessedData, error) {
 	time.Sleep(time.Millisecond * 500)
 	
 	if rand.Float32() < 0.3 {
-		return nil, errors.New("random data processing failure")
+		return ProcessedData{}, errors.New("random data processing failure")
 	}
 	
-	data := map[string]interface{}{
-		"processed_records": 1000,
-		"timestamp":         time.Now(),
-		"source":           "data_processing",
+	data := ProcessedData{
+		ProcessedRecords: 1000,
+		Timestamp:        time.Now(),
+		Source:           "data_processing",
 	}
 	
 	return data, nil
@@ -52,26 +64,21 @@ func NewValidationStage() *ValidationStage {
 	}
 }
 
-func (s *ValidationStage) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+func (s *ValidationStage) Execute(ctx context.Context, data ProcessedData) (ValidationReport, error) {
 	time.Sleep(time.Millisecond * 300)
 	
-	if input == nil {
-		return nil, errors.New("no input data to validate")
-	}
-	
-	data, ok := input.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("invalid input format")
+	if data.ProcessedRecords == 0 {
+		return ValidationReport{}, Permanent(errors.New("no input data to validate"))
 	}
 	
 	if rand.Float32() < 0.2 {
-		return nil, errors.New("validation failed")
+		return ValidationReport{}, errors.New("validation failed")
 	}
 	
-	result := map[string]interface{}{
-		"validation_passed": true,
-		"input_records":     data["processed_records"],
-		"validated_at":      time.Now(),
+	result := ValidationReport{
+		ValidationPassed: true,
+		InputRecords:     data.ProcessedRecords,
+		ValidatedAt:      time.Now(),
 	}
 	
 	return result, nil
@@ -85,7 +92,8 @@ func NewTransformationStage() *TransformationStage {
 	return &TransformationStage{
 		BaseStage: NewBaseStage("transformation", []string{"validation"}).
 			SetMaxRetries(3).
-			SetRetryDelay(time.Second * 1).
+			SetRetryPolicy(NewExponentialBackoff(time.Second*1, time.Second*5).SetJitter(FullJitter)).
+			SetRetryBudget(time.Second * 15).
 			SetTimeout(time.Second * 8),
 	}
 }
@@ -115,10 +123,20 @@ func NewOutputStage() *OutputStage {
 		BaseStage: NewBaseStage("output", []string{"transformation"}).
 			SetMaxRetries(2).
 			SetRetryDelay(time.Second * 2).
-			SetTimeout(time.Second * 5),
+			SetTimeout(time.Second * 5).
+			When(validationPassed),
 	}
 }
 
+func validationPassed(results map[string]*StageResult) bool {
+	result, ok := results["validation"]
+	if !ok {
+		return false
+	}
+	report, ok := result.Output.(ValidationReport)
+	return ok && report.ValidationPassed
+}
+
 func (s *OutputStage) Execute(ctx context.Context, input interface{}) (interface{}, error) {
 	time.Sleep(time.Millisecond * 200)
 	
@@ -135,7 +153,29 @@ func (s *OutputStage) Execute(ctx context.Context, input interface{}) (interface
 	return result, nil
 }
 
+func exampleRegistry() *StageRegistry {
+	factories := map[string]StageFactory{
+		"data_processing": func() Stage { return AdaptStage[struct{}, ProcessedData](NewDataProcessingStage()) },
+		"validation":      func() Stage { return AdaptStage[ProcessedData, ValidationReport](NewValidationStage()) },
+		"transformation":  func() Stage { return NewTransformationStage() },
+		"output":          func() Stage { return NewOutputStage() },
+	}
+	
+	registry := NewStageRegistry()
+	for kind, factory := range factories {
+		registry.Register(kind, factory)
+	}
+	return registry
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:], exampleRegistry(), os.Stdout, os.Stderr))
+	}
+	runDemo()
+}
+
+func runDemo() {
 	logger := log.New(os.Stdout, "[PIPELINE] ", log.LstdFlags)
 	
 	config := PipelineConfig{
@@ -145,12 +185,19 @@ func main() {
 		GlobalTimeout:     time.Minute * 2,
 	}
 	
-	pipeline := NewPipeline(config, logger)
+	pipeline := NewPipeline(config, LoggerFromStd(logger))
 	
-	pipeline.AddStage(NewDataProcessingStage())
-	pipeline.AddStage(NewValidationStage())
-	pipeline.AddStage(NewTransformationStage())
-	pipeline.AddStage(NewOutputStage())
+	stages := []Stage{
+		AdaptStage[struct{}, ProcessedData](NewDataProcessingStage()),
+		AdaptStage[ProcessedData, ValidationReport](NewValidationStage()),
+		NewTransformationStage(),
+		NewOutputStage(),
+	}
+	for _, stage := range stages {
+		if err := pipeline.AddStage(stage); err != nil {
+			logger.Fatalf("Failed to add stage: %v", err)
+		}
+	}
 	
 	fmt.Println("=== Starting Pipeline Execution ===")
 	
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestValidationPassedWithoutValidationStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		results map[string]*StageResult
+		want    bool
+	}{
+		{"no validation stage", map[string]*StageResult{}, false},
+		{"no report", map[string]*StageResult{"validation": {Status: StatusCompleted}}, false},
+		{"failed", map[string]*StageResult{"validation": {Output: ValidationReport{}}}, false},
+		{"passed", map[string]*StageResult{"validation": {Output: ValidationReport{ValidationPassed: true}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validationPassed(tt.results); got != tt.want {
+				t.Errorf("validationPassed is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Expansion is a set of stages, and of dependencies between stages, added
+// to a pipeline after it was built.
+type Expansion struct {
+	Stages []Stage
+	// Dependencies adds dependencies to new stages or to existing stages
+	// that have not started, by stage name. Added dependencies behave like
+	// declared ones, including for stage inputs.
+	Dependencies map[string][]string
+}
+
+type expanderKey struct{}
+
+// Expander adds stages to the pipeline that is running a stage. Stages get
+// it from their context with ExpanderFromContext.
+type Expander struct {
+	pipeline *Pipeline
+	stage    string
+}
+
+func ExpanderFromContext(ctx context.Context) (*Expander, bool) {
+	expander, ok := ctx.Value(expanderKey{}).(*Expander)
+	return expander, ok
+}
+
+// Expand adds the expansion to the pipeline, as Pipeline.Expand does.
+func (e *Expander) Expand(expansion Expansion) error {
+	e.pipeline.log().Info("Stage is expanding the pipeline", "stage", e.stage, "new_stages", len(expansion.Stages))
+	return e.pipeline.Expand(expansion)
+}
+
+// Expand adds stages and dependencies to the pipeline, which may be
+// executing. The expansion is validated like the rest of the pipeline,
+// including for dependency cycles, and is applied entirely or not at all. A
+// running pipeline schedules the new stages in the same run; they stay part
+// of the pipeline afterwards.
+func (p *Pipeline) Expand(expansion Expansion) error {
+	added, err := p.applyExpansion(expansion)
+	if err != nil {
+		return fmt.Errorf("invalid expansion: %w", err)
+	}
+
+	for _, name := range added {
+		p.emitStage(EventStageAdded, name, nil, 0)
+	}
+	p.skipBlockedStages()
+	p.signal()
+	return nil
+}
+
+func (p *Pipeline) applyExpansion(expansion Expansion) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	added := make([]string, 0, len(expansion.Stages))
+	isNew := make(map[string]bool, len(expansion.Stages))
+	for _, stage := range expansion.Stages {
+		name := stage.Name()
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("stage name must not be empty")
+		case isNew[name]:
+			return nil, fmt.Errorf("stage %s is added more than once", name)
+		case p.stages[name] != nil:
+			return nil, fmt.Errorf("stage %s already exists", name)
+		}
+		isNew[name] = true
+		added = append(added, name)
+	}
+
+	for _, name := range sortedKeys(expansion.Dependencies) {
+		if isNew[name] {
+			continue
+		}
+		result, exists := p.results[name]
+		if !exists {
+			return nil, fmt.Errorf("cannot add dependencies to non-existent stage %s", name)
+		}
+		if _, running := p.inflight[name]; running || result.Status != StatusPending {
+			return nil, fmt.Errorf("cannot add dependencies to stage %s, which is %s", name, result.Status)
+		}
+	}
+
+	previous := make(map[string][]string, len(expansion.Dependencies))
+	for _, stage := range expansion.Stages {
+		p.stages[stage.Name()] = stage
+		p.results[stage.Name()] = &StageResult{Status: StatusPending}
+	}
+	for name, deps := range expansion.Dependencies {
+		previous[name] = p.extraDeps[name]
+		p.extraDeps[name] = append(append([]string(nil), p.extraDeps[name]...), deps...)
+	}
+
+	if err := p.validateExpansionLocked(); err != nil {
+		for _, name := range added {
+			delete(p.stages, name)
+			delete(p.results, name)
+		}
+		for name, deps := range previous {
+			if isNew[name] || deps == nil {
+				delete(p.extraDeps, name)
+			} else {
+				p.extraDeps[name] = deps
+			}
+		}
+		return nil, err
+	}
+
+	for _, name := range added {
+		p.checkpointLocked(name)
+	}
+	p.log().Info("Expanded pipeline", "new_stages", added, "new_dependencies", len(expansion.Dependencies))
+	return added, nil
+}
+
+func (p *Pipeline) validateExpansionLocked() error {
+	if err := p.validateGraph(); err != nil {
+		return err
+	}
+	if err := p.validateStageTypes(); err != nil {
+		return err
+	}
+	return p.validateResources()
+}
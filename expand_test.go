@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandSchedulesNewStagesInTheSameRun(t *testing.T) {
+	discover := newTestStage("discover", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		expansion := Expansion{Dependencies: map[string][]string{}}
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("part-%d", i)
+			expansion.Stages = append(expansion.Stages, newTestStage(name, []string{"discover"}, sleepStage(name, 10*time.Millisecond)))
+			expansion.Dependencies["merge"] = append(expansion.Dependencies["merge"], name)
+		}
+		expander, ok := ExpanderFromContext(ctx)
+		if !ok {
+			return nil, Permanent(fmt.Errorf("no expander in context"))
+		}
+		return nil, expander.Expand(expansion)
+	})
+	var merged Inputs
+	merge := newTestStage("merge", []string{"discover"}, func(ctx context.Context, input interface{}) (interface{}, error) {
+		merged = input.(Inputs)
+		return nil, nil
+	})
+	p := newTestPipeline(t, PipelineConfig{MaxConcurrency: 2}, discover, merge)
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("part-%d", i)
+		if merged[name] != name {
+			t.Errorf("merge got %v from %s, want %q", merged[name], name, name)
+		}
+	}
+}
+
+func TestExpandRejectsCyclesAndRollsBack(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("a", nil, sleepStage("a", 0)),
+		newTestStage("b", []string{"a"}, sleepStage("b", 0)),
+	)
+
+	err := p.Expand(Expansion{
+		Stages:       []Stage{newTestStage("c", []string{"b"}, sleepStage("c", 0))},
+		Dependencies: map[string][]string{"a": {"c"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("Expand returned %v, want a cycle error", err)
+	}
+	if _, ok := p.GetStageResult("c"); ok {
+		t.Errorf("stage c was added by a rejected expansion")
+	}
+	if deps := p.deps("a"); len(deps) != 0 {
+		t.Errorf("stage a has dependencies %v after a rejected expansion", deps)
+	}
+}
+
+// TestExpandWhileSchedulingConditionalStages is meant to run with -race: a
+// stage expands the pipeline while the scheduler checks conditions and
+// launches stages.
+func TestExpandWhileSchedulingConditionalStages(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{MaxConcurrency: 4})
+	p.AddStage(newTestStage("expander", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		expander, _ := ExpanderFromContext(ctx)
+		for i := 0; i < 200; i++ {
+			name := fmt.Sprintf("added-%d", i)
+			stage := newTestStage(name, nil, sleepStage(name, time.Millisecond))
+			if err := expander.Expand(Expansion{Stages: []Stage{stage}}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}))
+	for i := 0; i < 50; i++ {
+		var deps []string
+		if i > 0 {
+			deps = []string{fmt.Sprintf("conditional-%d", i-1)}
+		}
+		name := fmt.Sprintf("conditional-%d", i)
+		stage := newTestStage(name, deps, sleepStage(name, 0))
+		stage.When(func(results map[string]*StageResult) bool { return true })
+		p.AddStage(stage)
+	}
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	for name, result := range p.GetAllResults() {
+		if result.Status != StatusCompleted {
+			t.Errorf("stage %s is %s", name, result.Status)
+		}
+	}
+}
+
+// TestExpandDependencyOfReadyStage adds a dependency to stages that are
+// already ready to run. None of them may start before it completes.
+func TestExpandDependencyOfReadyStage(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		gate := make(chan struct{})
+		p := newTestPipeline(t, PipelineConfig{MaxConcurrency: 1})
+		first := newTestStage("first", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			<-gate
+			return nil, nil
+		})
+		first.SetPriority(1)
+		p.AddStage(first)
+		p.AddStage(newTestStage("waiting", nil, sleepStage("waiting", 0)))
+
+		errs := make(chan error, 1)
+		go func() { errs <- p.Execute() }()
+		for p.stageStatus("first") != StatusRunning {
+			time.Sleep(time.Millisecond)
+		}
+		err := p.Expand(Expansion{
+			Stages:       []Stage{newTestStage("late", nil, sleepStage("late", 5*time.Millisecond))},
+			Dependencies: map[string][]string{"waiting": {"late"}},
+		})
+		if err != nil {
+			t.Fatalf("Expand: %v", err)
+		}
+		close(gate)
+		if err := <-errs; err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+
+		late, _ := p.GetStageResult("late")
+		waiting, _ := p.GetStageResult("waiting")
+		if waiting.StartTime.Before(late.EndTime) {
+			t.Fatalf("round %d: stage waiting started before its new dependency late ended", round)
+		}
+	}
+}
+
+func TestRestoreRunRejectsUnknownStages(t *testing.T) {
+	store := NewFileCheckpointStore(t.TempDir())
+	newPipeline := func() *Pipeline {
+		p := newTestPipeline(t, PipelineConfig{},
+			newTestStage("discover", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+				expander, _ := ExpanderFromContext(ctx)
+				return "done", expander.Expand(Expansion{
+					Stages: []Stage{newTestStage("part", []string{"discover"}, sleepStage("part", 0))},
+				})
+			}),
+		)
+		p.SetCheckpointStore(store)
+		return p
+	}
+
+	p := newPipeline()
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	restored := newPipeline()
+	err := restored.RestoreRun(p.RunID())
+	if err == nil || !strings.Contains(err.Error(), "part") {
+		t.Fatalf("RestoreRun returned %v, want an error naming stage part", err)
+	}
+
+	restored.Expand(Expansion{Stages: []Stage{newTestStage("part", []string{"discover"}, sleepStage("part", 0))}})
+	if err := restored.RestoreRun(p.RunID()); err != nil {
+		t.Fatalf("RestoreRun after Expand: %v", err)
+	}
+	assertStatuses(t, restored, map[string]StageStatus{"discover": StatusCompleted, "part": StatusCompleted})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var statusColors = map[StageStatus]string{
+	StatusPending:   "#d3d3d3",
+	StatusRunning:   "#87cefa",
+	StatusCompleted: "#90ee90",
+	StatusFailed:    "#f08080",
+	StatusSkipped:   "#ffe08a",
+	StatusCancelled: "#c9a0dc",
+}
+
+// WriteDOT writes the stage graph in Graphviz DOT format, with an edge from
+// every dependency to its dependent. With showStatus, nodes are labelled and
+// coloured with the current status of their stage.
+func (p *Pipeline) WriteDOT(w io.Writer, showStatus bool) error {
+	var results map[string]*StageResult
+	if showStatus {
+		results = p.snapshotResults()
+	}
+	return writeDOT(w, p.dependencyGraph(), results)
+}
+
+// WriteMermaid writes the stage graph as a Mermaid flowchart, like WriteDOT.
+func (p *Pipeline) WriteMermaid(w io.Writer, showStatus bool) error {
+	var results map[string]*StageResult
+	if showStatus {
+		results = p.snapshotResults()
+	}
+	return writeMermaid(w, p.dependencyGraph(), results)
+}
+
+func sortedGraphNames(graph map[string][]string) []string {
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeDOT(w io.Writer, graph map[string][]string, results map[string]*StageResult) error {
+	bw := bufio.NewWriter(w)
+	names := sortedGraphNames(graph)
+
+	fmt.Fprintln(bw, "digraph pipeline {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\"];")
+
+	for _, name := range names {
+		result, ok := results[name]
+		if !ok {
+			fmt.Fprintf(bw, "  %s;\n", strconv.Quote(name))
+			continue
+		}
+		label := fmt.Sprintf("%s\n%s", name, result.Status)
+		fmt.Fprintf(bw, "  %s [label=%s, fillcolor=%s];\n",
+			strconv.Quote(name), strconv.Quote(label), strconv.Quote(statusColors[result.Status]))
+	}
+
+	for _, name := range names {
+		for _, dep := range graph[name] {
+			fmt.Fprintf(bw, "  %s -> %s;\n", strconv.Quote(dep), strconv.Quote(name))
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func writeMermaid(w io.Writer, graph map[string][]string, results map[string]*StageResult) error {
+	bw := bufio.NewWriter(w)
+	names := sortedGraphNames(graph)
+
+	// Stage names may contain characters Mermaid does not accept in node IDs.
+	ids := make(map[string]string, len(names))
+	for i, name := range names {
+		ids[name] = fmt.Sprintf("s%d", i)
+	}
+
+	fmt.Fprintln(bw, "flowchart LR")
+	for _, name := range names {
+		label := name
+		if result, ok := results[name]; ok {
+			label = fmt.Sprintf("%s<br/>%s", name, result.Status)
+		}
+		fmt.Fprintf(bw, "  %s[\"%s\"]\n", ids[name], strings.ReplaceAll(label, `"`, "#quot;"))
+	}
+
+	for _, name := range names {
+		for _, dep := range graph[name] {
+			fmt.Fprintf(bw, "  %s --> %s\n", ids[dep], ids[name])
+		}
+	}
+
+	if results != nil {
+		for status := StatusPending; status <= StatusCancelled; status++ {
+			var members []string
+			for _, name := range names {
+				if result, ok := results[name]; ok && result.Status == status {
+					members = append(members, ids[name])
+				}
+			}
+			if len(members) == 0 {
+				continue
+			}
+			class := strings.ToLower(status.String())
+			fmt.Fprintf(bw, "  classDef %s fill:%s\n", class, statusColors[status])
+			fmt.Fprintf(bw, "  class %s %s\n", strings.Join(members, ","), class)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func (p *Pipeline) stageNames() []string {
+	names := make([]string, 0, len(p.stages))
+	for name := range p.stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deps returns the dependencies of the named stage: those it declares and
+// those added by Expand.
+func (p *Pipeline) deps(name string) []string {
+	declared := p.stages[name].Dependencies()
+	extra := p.extraDeps[name]
+	if len(extra) == 0 {
+		return declared
+	}
+	return append(append(make([]string, 0, len(declared)+len(extra)), declared...), extra...)
+}
+
+func (p *Pipeline) dependencyGraph() map[string][]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	graph := make(map[string][]string, len(p.stages))
+	for name := range p.stages {
+		graph[name] = append([]string(nil), p.deps(name)...)
+	}
+	return graph
+}
+
+// findCycle returns the first dependency cycle found, as a path that starts
+// and ends with the same stage, or nil if the graph is acyclic.
+func (p *Pipeline) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(p.stages))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range p.deps(name) {
+			if _, exists := p.stages[dep]; !exists {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, n := range path {
+					if n == dep {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range p.stageNames() {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) validateGraph() error {
+	for _, name := range p.stageNames() {
+		seen := make(map[string]bool)
+		for _, dep := range p.deps(name) {
+			if dep == name {
+				return fmt.Errorf("stage %s depends on itself", name)
+			}
+			if seen[dep] {
+				return fmt.Errorf("stage %s lists dependency %s more than once", name, dep)
+			}
+			seen[dep] = true
+			if _, exists := p.stages[dep]; !exists {
+				return fmt.Errorf("stage %s depends on non-existent stage %s", name, dep)
+			}
+		}
+	}
+
+	if cycle := p.findCycle(); cycle != nil {
+		return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+)
+
+type stdLogWriter struct {
+	logger *log.Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.Print(string(bytes.TrimSuffix(p, []byte("\n"))))
+	return len(p), nil
+}
+
+// LoggerFromStd returns a structured logger that writes key=value records
+// through l, keeping its prefix and flags, for callers that still configure
+// logging with a *log.Logger.
+func LoggerFromStd(l *log.Logger) *slog.Logger {
+	return slog.New(slog.NewTextHandler(stdLogWriter{logger: l}, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// l adds its own timestamp.
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ItemError is the failure of one item of a MapStage, after its retries.
+type ItemError struct {
+	Index    int
+	Attempts int
+	Err      error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error { return e.Err }
+
+// MapError is returned by a MapStage when some of its items failed. Output
+// holds the []Out of the stage, with the zero value for the failed items.
+type MapError struct {
+	Items  int
+	Failed []*ItemError
+	Output interface{}
+}
+
+func (e *MapError) Error() string {
+	messages := make([]string, len(e.Failed))
+	for i, itemErr := range e.Failed {
+		messages[i] = itemErr.Error()
+	}
+	return fmt.Sprintf("%d of %d items failed: %s", len(e.Failed), e.Items, strings.Join(messages, "; "))
+}
+
+// ItemFunc processes one item of a MapStage.
+type ItemFunc[In, Out any] func(ctx context.Context, item In) (Out, error)
+
+// MapStage runs a function once per element of the []In output of its
+// dependency and outputs the results as a []Out in the same order. Items run
+// concurrently, up to the stage's concurrency limit, and are retried one by
+// one, so a failing item does not run the others again.
+//
+// If items still fail after their retries, the stage fails with a permanent
+// *MapError that lists them. Cancelling the stage, or its timeout, stops the
+// items and returns the context error instead.
+type MapStage[In, Out any] struct {
+	*BaseStage
+	fn          ItemFunc[In, Out]
+	concurrency int
+	itemRetries int
+	itemPolicy  RetryPolicy
+}
+
+func NewMapStage[In, Out any](name string, deps []string, fn ItemFunc[In, Out]) *MapStage[In, Out] {
+	return &MapStage[In, Out]{
+		BaseStage: NewBaseStage(name, deps),
+		fn:        fn,
+	}
+}
+
+// SetConcurrency limits how many items run at the same time. Zero, the
+// default, runs all items at once.
+func (s *MapStage[In, Out]) SetConcurrency(concurrency int) *MapStage[In, Out] {
+	s.concurrency = concurrency
+	return s
+}
+
+// SetItemRetries sets how many times a failed item is retried. Items are not
+// retried by default.
+func (s *MapStage[In, Out]) SetItemRetries(retries int) *MapStage[In, Out] {
+	s.itemRetries = retries
+	return s
+}
+
+// SetItemRetryPolicy sets the delays between the attempts of an item. The
+// default waits the stage's RetryDelay. Permanent and RetryAfter errors from
+// the item function are honoured as they are for stages.
+func (s *MapStage[In, Out]) SetItemRetryPolicy(policy RetryPolicy) *MapStage[In, Out] {
+	s.itemPolicy = policy
+	return s
+}
+
+func (s *MapStage[In, Out]) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+	var items []In
+	if input != nil {
+		typed, ok := input.([]In)
+		if !ok {
+			return nil, Permanent(fmt.Errorf("stage %s expects input of type %s, got %T", s.Name(), typeOf[[]In](), input))
+		}
+		items = typed
+	}
+
+	workers := s.concurrency
+	if workers <= 0 || workers > len(items) {
+		workers = len(items)
+	}
+
+	outputs := make([]Out, len(items))
+	itemErrs := make([]*ItemError, len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				outputs[index], itemErrs[index] = s.runItem(ctx, index, items[index])
+			}
+		}()
+	}
+
+feed:
+	for index := range items {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var failed []*ItemError
+	for _, itemErr := range itemErrs {
+		if itemErr != nil {
+			failed = append(failed, itemErr)
+		}
+	}
+	if len(failed) > 0 {
+		return nil, Permanent(&MapError{Items: len(items), Failed: failed, Output: outputs})
+	}
+	return outputs, nil
+}
+
+// runItem calls the item function until it succeeds, its error is permanent
+// or it has no retries left.
+func (s *MapStage[In, Out]) runItem(ctx context.Context, index int, item In) (Out, *ItemError) {
+	policy := s.itemPolicy
+	if policy == nil {
+		policy = FixedDelay{Delay: s.RetryDelay()}
+	}
+
+	var zero Out
+	firstStart := time.Now()
+	var lastDelay time.Duration
+	for attempt := 1; ; attempt++ {
+		out, err := s.fn(ctx, item)
+		if err == nil {
+			return out, nil
+		}
+		itemErr := &ItemError{Index: index, Attempts: attempt, Err: err}
+		if IsPermanent(err) || attempt > s.itemRetries || ctx.Err() != nil {
+			return zero, itemErr
+		}
+
+		delay, retry := nextRetryDelay(policy, RetryState{
+			Attempt:   attempt,
+			Elapsed:   time.Since(firstStart),
+			LastDelay: lastDelay,
+			Err:       err,
+		})
+		if !retry {
+			return zero, itemErr
+		}
+		lastDelay = delay
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, itemErr
+		}
+	}
+}
+
+func (s *MapStage[In, Out]) stageTypes() (reflect.Type, reflect.Type) {
+	return typeOf[[]In](), typeOf[[]Out]()
+}
+
+func (s *MapStage[In, Out]) decodeOutput(data []byte) (interface{}, error) {
+	var out []Out
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newNumbersStage(numbers ...int) *testStage {
+	return newTestStage("numbers", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		return numbers, nil
+	})
+}
+
+func TestMapStageKeepsItemOrder(t *testing.T) {
+	var running, peak atomic.Int32
+	square := NewMapStage("square", []string{"numbers"}, func(ctx context.Context, n int) (int, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for old := peak.Load(); current > old && !peak.CompareAndSwap(old, current); old = peak.Load() {
+		}
+		time.Sleep(time.Duration(10-n) * time.Millisecond)
+		return n * n, nil
+	}).SetConcurrency(3)
+	p := newTestPipeline(t, PipelineConfig{}, newNumbersStage(1, 2, 3, 4, 5, 6, 7, 8, 9), square)
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	result, _ := p.GetStageResult("square")
+	if got := fmt.Sprint(result.Output); got != "[1 4 9 16 25 36 49 64 81]" {
+		t.Errorf("output is %s", got)
+	}
+	if got := peak.Load(); got != 3 {
+		t.Errorf("peak concurrency is %d, want 3", got)
+	}
+}
+
+func TestMapStageReportsFailedItems(t *testing.T) {
+	attempts := make([]atomic.Int32, 5)
+	stage := NewMapStage("items", []string{"numbers"}, func(ctx context.Context, n int) (string, error) {
+		attempt := attempts[n].Add(1)
+		switch {
+		case n == 1 && attempt < 3:
+			return "", errors.New("flaky")
+		case n == 3:
+			return "", Permanent(errors.New("bad item"))
+		}
+		return fmt.Sprint(n), nil
+	}).SetItemRetries(3).SetItemRetryPolicy(FixedDelay{Delay: time.Millisecond})
+	stage.SetMaxRetries(2)
+	p := newTestPipeline(t, PipelineConfig{}, newNumbersStage(0, 1, 2, 3, 4), stage)
+
+	p.Execute()
+	result, _ := p.GetStageResult("items")
+	if result.Status != StatusFailed || result.Attempts != 1 {
+		t.Fatalf("stage is %s after %d attempts, want FAILED after 1", result.Status, result.Attempts)
+	}
+	var mapErr *MapError
+	if !errors.As(result.Error, &mapErr) {
+		t.Fatalf("stage error %v is not a *MapError", result.Error)
+	}
+	if len(mapErr.Failed) != 1 || mapErr.Failed[0].Index != 3 || mapErr.Failed[0].Attempts != 1 {
+		t.Errorf("failed items are %v, want item 3 after 1 attempt", mapErr.Failed)
+	}
+	if got := fmt.Sprintf("%q", mapErr.Output); got != `["0" "1" "2" "" "4"]` {
+		t.Errorf("partial output is %s", got)
+	}
+	if got := attempts[1].Load(); got != 3 {
+		t.Errorf("flaky item ran %d times, want 3", got)
+	}
+}
+
+func TestMapStageRetryAfterCountsAgainstRetryBudget(t *testing.T) {
+	stage := NewMapStage("items", []string{"numbers"}, func(ctx context.Context, n int) (int, error) {
+		return 0, RetryAfter(errors.New("busy"), 2*time.Second)
+	}).SetItemRetries(3).SetItemRetryPolicy(WithRetryBudget(FixedDelay{Delay: time.Millisecond}, 100*time.Millisecond))
+	p := newTestPipeline(t, PipelineConfig{}, newNumbersStage(1), stage)
+
+	start := time.Now()
+	p.Execute()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("item waited %s for a hint past its retry budget", elapsed)
+	}
+}
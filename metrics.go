@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Metrics collects counters, gauges and histograms from pipeline events and
+// exposes them in the Prometheus text exposition format.
+type Metrics struct {
+	mu      sync.Mutex
+	buckets []float64
+
+	attempts        map[string]float64
+	attemptFailures map[string]float64
+	retries         map[string]float64
+	successes       map[string]float64
+	failures        map[string]float64
+	skipped         map[string]float64
+	cancellations   map[string]float64
+	durations       map[string]*histogram
+	running         map[string]bool
+	runs            map[string]float64
+
+	maxConcurrency int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:         defaultDurationBuckets,
+		attempts:        make(map[string]float64),
+		attemptFailures: make(map[string]float64),
+		retries:         make(map[string]float64),
+		successes:       make(map[string]float64),
+		failures:        make(map[string]float64),
+		skipped:         make(map[string]float64),
+		cancellations:   make(map[string]float64),
+		durations:       make(map[string]*histogram),
+		running:         make(map[string]bool),
+		runs:            make(map[string]float64),
+	}
+}
+
+// Register subscribes m to the events of p and records its MaxConcurrency.
+func (m *Metrics) Register(p *Pipeline) (unsubscribe func()) {
+	m.mu.Lock()
+	m.maxConcurrency = p.config.MaxConcurrency
+	m.mu.Unlock()
+
+	return p.Subscribe(m)
+}
+
+func (m *Metrics) OnEvent(event Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event.Type {
+	case EventStageStarted:
+		m.attempts[event.Stage]++
+		m.running[event.Stage] = true
+	case EventAttemptFailed:
+		m.attemptFailures[event.Stage]++
+	case EventRetryScheduled:
+		m.retries[event.Stage]++
+	case EventStageCompleted:
+		m.successes[event.Stage]++
+		m.observeDuration(event.Stage, event.Result.Duration.Seconds())
+		delete(m.running, event.Stage)
+	case EventStageFailed:
+		m.failures[event.Stage]++
+		m.observeDuration(event.Stage, event.Result.Duration.Seconds())
+		delete(m.running, event.Stage)
+	case EventStageSkipped:
+		m.skipped[event.Stage]++
+		delete(m.running, event.Stage)
+	case EventStageInterrupted:
+		delete(m.running, event.Stage)
+	case EventStageCancelled:
+		m.cancellations[event.Stage]++
+		delete(m.running, event.Stage)
+	case EventPipelineFinished:
+		outcome := "success"
+		if event.Err != nil {
+			outcome = "failure"
+		}
+		m.runs[outcome]++
+	}
+}
+
+func (m *Metrics) observeDuration(stage string, seconds float64) {
+	h, ok := m.durations[stage]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[stage] = h
+	}
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteText(w)
+	})
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	writeCounter(bw, "pipeline_stage_attempts_total", "Number of stage attempts started.", "stage", m.attempts)
+	writeCounter(bw, "pipeline_stage_attempt_failures_total", "Number of failed stage attempts.", "stage", m.attemptFailures)
+	writeCounter(bw, "pipeline_stage_retries_total", "Number of stage retries scheduled.", "stage", m.retries)
+	writeCounter(bw, "pipeline_stage_successes_total", "Number of stages that completed.", "stage", m.successes)
+	writeCounter(bw, "pipeline_stage_failures_total", "Number of stages that failed after all attempts.", "stage", m.failures)
+	writeCounter(bw, "pipeline_stage_skipped_total", "Number of stages that were skipped.", "stage", m.skipped)
+	writeCounter(bw, "pipeline_stage_cancellations_total", "Number of stages cancelled while running.", "stage", m.cancellations)
+	writeCounter(bw, "pipeline_runs_total", "Number of pipeline runs by outcome.", "outcome", m.runs)
+
+	fmt.Fprintln(bw, "# HELP pipeline_running_stages Number of stages currently running or waiting for a retry.")
+	fmt.Fprintln(bw, "# TYPE pipeline_running_stages gauge")
+	fmt.Fprintf(bw, "pipeline_running_stages %d\n", len(m.running))
+
+	fmt.Fprintln(bw, "# HELP pipeline_max_concurrency Maximum number of stages running at the same time, 0 for no limit.")
+	fmt.Fprintln(bw, "# TYPE pipeline_max_concurrency gauge")
+	fmt.Fprintf(bw, "pipeline_max_concurrency %d\n", m.maxConcurrency)
+
+	fmt.Fprintln(bw, "# HELP pipeline_stage_duration_seconds Duration of the last attempt of finished stages.")
+	fmt.Fprintln(bw, "# TYPE pipeline_stage_duration_seconds histogram")
+	for _, stage := range sortedKeys(m.durations) {
+		h := m.durations[stage]
+		label := fmt.Sprintf("stage=\"%s\"", escapeLabel(stage))
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(bw, "pipeline_stage_duration_seconds_bucket{%s,le=%q} %d\n", label, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(bw, "pipeline_stage_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(bw, "pipeline_stage_duration_seconds_sum{%s} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(bw, "pipeline_stage_duration_seconds_count{%s} %d\n", label, h.count)
+	}
+
+	return bw.Flush()
+}
+
+func writeCounter(w io.Writer, name, help, labelName string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, label := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", name, labelName, escapeLabel(label), formatFloat(values[label]))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
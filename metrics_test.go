@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMetricsWriteText(t *testing.T) {
+	var calls atomic.Int32
+	flaky := newTestStage(`flaky "quoted" \ stage`, nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("boom")
+		}
+		return nil, nil
+	})
+	flaky.SetMaxRetries(1)
+	p := newTestPipeline(t, PipelineConfig{ContinueOnFailure: true, MaxConcurrency: 2},
+		flaky,
+		newTestStage("bad", nil, failStage),
+	)
+	metrics := NewMetrics()
+	metrics.Register(p)
+
+	p.Execute()
+	var out strings.Builder
+	if err := metrics.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	text := out.String()
+
+	for _, line := range []string{
+		`pipeline_stage_attempts_total{stage="flaky \"quoted\" \\ stage"} 2`,
+		`pipeline_stage_attempt_failures_total{stage="flaky \"quoted\" \\ stage"} 1`,
+		`pipeline_stage_retries_total{stage="flaky \"quoted\" \\ stage"} 1`,
+		`pipeline_stage_successes_total{stage="flaky \"quoted\" \\ stage"} 1`,
+		`pipeline_stage_attempts_total{stage="bad"} 1`,
+		`pipeline_stage_failures_total{stage="bad"} 1`,
+		`pipeline_running_stages 0`,
+		`pipeline_max_concurrency 2`,
+		`pipeline_stage_duration_seconds_count{stage="bad"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("output has no line %s\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, `pipeline_stage_retries_total{stage="bad"}`) {
+		t.Errorf("stage bad without retries has a retry count\n%s", text)
+	}
+
+	// Buckets are cumulative and the +Inf bucket counts every observation.
+	buckets := make(map[string][]int)
+	counts := make(map[string]int)
+	for _, line := range strings.Split(text, "\n") {
+		i := strings.LastIndex(line, " ")
+		if i < 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:i]
+		n, _ := strconv.Atoi(line[i+1:])
+		labels := strings.TrimSuffix(name[strings.Index(name, "{")+1:], "}")
+		stage, _, _ := strings.Cut(labels, ",le=")
+		switch {
+		case strings.HasPrefix(name, "pipeline_stage_duration_seconds_bucket"):
+			buckets[stage] = append(buckets[stage], n)
+		case strings.HasPrefix(name, "pipeline_stage_duration_seconds_count"):
+			counts[stage] = n
+		}
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("histogram has %d stages, want 2\n%s", len(buckets), text)
+	}
+	for stage, values := range buckets {
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				t.Errorf("%s: bucket %d has %d, less than the previous %d", stage, i, values[i], values[i-1])
+			}
+		}
+		if inf := values[len(values)-1]; inf != counts[stage] || inf != 1 {
+			t.Errorf("%s: +Inf bucket is %d, count is %d, want 1", stage, inf, counts[stage])
+		}
+	}
+}
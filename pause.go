@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+)
+
+// ErrPaused is the cancellation cause of stages interrupted by Pause.
+var ErrPaused = errors.New("pipeline paused")
+
+// Pause stops the scheduler from starting new stages until Resume is called;
+// Execute keeps waiting in the meantime. Running stages finish normally,
+// unless interrupt is set: then their contexts are cancelled with ErrPaused,
+// which context.Cause reports, and a stage that returns an error because of
+// it goes back to pending, to run again after Resume. Pausing a pipeline
+// that is not executing makes the next Execute wait for Resume.
+func (p *Pipeline) Pause(interrupt bool) {
+	if !p.paused.CompareAndSwap(false, true) && !interrupt {
+		return
+	}
+
+	p.log().Info("Pausing pipeline", "interrupt", interrupt)
+	if interrupt {
+		p.mu.RLock()
+		for _, cancel := range p.inflight {
+			cancel(ErrPaused)
+		}
+		p.mu.RUnlock()
+	}
+	p.emit(Event{Type: EventPipelinePaused, RunID: p.RunID()})
+}
+
+// Resume lets a paused pipeline start stages again, carrying on from the
+// current stage results.
+func (p *Pipeline) Resume() {
+	if !p.paused.CompareAndSwap(true, false) {
+		return
+	}
+
+	p.log().Info("Resuming pipeline")
+	p.emit(Event{Type: EventPipelineResumed, RunID: p.RunID()})
+	p.signal()
+}
+
+func (p *Pipeline) IsPaused() bool {
+	return p.paused.Load()
+}
+
+// requeueInterrupted returns a stage interrupted by Pause to pending.
+func (p *Pipeline) requeueInterrupted(name string, err error) {
+	p.mu.Lock()
+	resetResult(p.results[name])
+	p.checkpointLocked(name)
+	p.mu.Unlock()
+
+	p.log().Info("Stage interrupted by pause, it will run again on resume", "stage", name, "error", err)
+	p.emitStage(EventStageInterrupted, name, err, 0)
+}
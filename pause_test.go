@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseWaitsForRunningStages(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("first", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			started <- struct{}{}
+			<-release
+			return "first", nil
+		}),
+		newTestStage("second", []string{"first"}, sleepStage("second", 0)),
+	)
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Execute() }()
+	<-started
+	p.Pause(false)
+	close(release)
+
+	for p.stageStatus("first") != StatusCompleted {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	assertStatuses(t, p, map[string]StageStatus{"second": StatusPending})
+	if !p.IsRunning() {
+		t.Fatal("paused pipeline stopped executing")
+	}
+
+	p.Resume()
+	if err := <-errs; err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	assertStatuses(t, p, map[string]StageStatus{"first": StatusCompleted, "second": StatusCompleted})
+}
+
+func TestPauseInterruptRequeuesRunningStages(t *testing.T) {
+	var runs atomic.Int32
+	started := make(chan struct{}, 2)
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("long", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			if runs.Add(1) > 1 {
+				return "long", nil
+			}
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, context.Cause(ctx)
+		}),
+	)
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Execute() }()
+	<-started
+	p.Pause(true)
+	for p.stageStatus("long") != StatusPending {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Resume()
+	if err := <-errs; err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	result, _ := p.GetStageResult("long")
+	if result.Status != StatusCompleted || runs.Load() != 2 {
+		t.Errorf("stage is %s after %d runs, want COMPLETED after 2", result.Status, runs.Load())
+	}
+	if errors.Is(result.Error, ErrPaused) {
+		t.Errorf("completed stage kept the pause error")
+	}
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +22,7 @@ const (
 	StatusCompleted
 	StatusFailed
 	StatusSkipped
+	StatusCancelled
 )
 
 func (s StageStatus) String() string {
@@ -30,19 +37,63 @@ func (s StageStatus) String() string {
 		return "FAILED"
 	case StatusSkipped:
 		return "SKIPPED"
+	case StatusCancelled:
+		return "CANCELLED"
 	default:
 		return "UNKNOWN"
 	}
 }
 
+func (s StageStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *StageStatus) UnmarshalText(text []byte) error {
+	for status := StatusPending; status <= StatusCancelled; status++ {
+		if status.String() == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown stage status %q", text)
+}
+
 type StageResult struct {
-	Status    StageStatus
-	Error     error
-	Output    interface{}
-	Duration  time.Duration
-	Attempts  int
-	StartTime time.Time
-	EndTime   time.Time
+	Status     StageStatus
+	Error      error
+	Output     interface{}
+	Duration   time.Duration
+	Attempts   int
+	StartTime  time.Time
+	EndTime    time.Time
+	SkipReason string
+	// SubStages holds the results of the stages of a sub-pipeline run by a
+	// PipelineStage. It is only set in checkpoints and in the results of
+	// PrintStatus, the API and the dashboard, not in those of GetStageResult
+	// and GetAllResults.
+	SubStages map[string]*StageResult
+}
+
+type Inputs map[string]interface{}
+
+func (in Inputs) Get(name string) (interface{}, bool) {
+	output, ok := in[name]
+	return output, ok
+}
+
+type inputsKey struct{}
+
+func InputsFromContext(ctx context.Context) Inputs {
+	inputs, _ := ctx.Value(inputsKey{}).(Inputs)
+	return inputs
+}
+
+// Condition decides whether a stage runs. It receives a snapshot of the
+// results of every stage in the pipeline.
+type Condition func(results map[string]*StageResult) bool
+
+type conditionalStage interface {
+	Condition() Condition
 }
 
 type Stage interface {
@@ -59,7 +110,12 @@ type BaseStage struct {
 	dependencies []string
 	maxRetries   int
 	retryDelay   time.Duration
+	retryPolicy  RetryPolicy
+	retryBudget  time.Duration
 	timeout      time.Duration
+	condition    Condition
+	resources    map[string]int
+	priority     int
 }
 
 func NewBaseStage(name string, deps []string) *BaseStage {
@@ -78,6 +134,8 @@ func (s *BaseStage) MaxRetries() int           { return s.maxRetries }
 func (s *BaseStage) RetryDelay() time.Duration { return s.retryDelay }
 func (s *BaseStage) Timeout() time.Duration    { return s.timeout }
 
+func (s *BaseStage) baseStage() *BaseStage { return s }
+
 func (s *BaseStage) SetMaxRetries(retries int) *BaseStage {
 	s.maxRetries = retries
 	return s
@@ -93,48 +151,174 @@ func (s *BaseStage) SetTimeout(timeout time.Duration) *BaseStage {
 	return s
 }
 
+func (s *BaseStage) SetRetryPolicy(policy RetryPolicy) *BaseStage {
+	s.retryPolicy = policy
+	return s
+}
+
+func (s *BaseStage) SetRetryBudget(budget time.Duration) *BaseStage {
+	s.retryBudget = budget
+	return s
+}
+
+// When makes the stage run only if condition holds once its dependencies have
+// completed. Otherwise the stage and its dependents are skipped.
+func (s *BaseStage) When(condition Condition) *BaseStage {
+	s.condition = condition
+	return s
+}
+
+func (s *BaseStage) Condition() Condition { return s.condition }
+
+// Require makes the stage hold amount units of the named resource pool while
+// it runs. Pool capacities are set in PipelineConfig.ResourcePools.
+func (s *BaseStage) Require(pool string, amount int) *BaseStage {
+	if s.resources == nil {
+		s.resources = make(map[string]int)
+	}
+	s.resources[pool] = amount
+	return s
+}
+
+func (s *BaseStage) Resources() map[string]int { return s.resources }
+
+// SetPriority sets the scheduling priority of the stage. When more stages
+// are ready than can start, higher priorities start first. The default is 0.
+func (s *BaseStage) SetPriority(priority int) *BaseStage {
+	s.priority = priority
+	return s
+}
+
+func (s *BaseStage) Priority() int { return s.priority }
+
+func (s *BaseStage) RetryPolicy() RetryPolicy {
+	var policy RetryPolicy = FixedDelay{Delay: s.retryDelay}
+	if s.retryPolicy != nil {
+		policy = s.retryPolicy
+	}
+	if s.retryBudget > 0 {
+		policy = WithRetryBudget(policy, s.retryBudget)
+	}
+	return policy
+}
+
 type PipelineConfig struct {
 	MaxConcurrency    int
 	FailFast          bool
 	ContinueOnFailure bool
 	GlobalTimeout     time.Duration
+	// ResourcePools maps resource pool names to their capacity. Stages that
+	// require resources only start when enough units are free.
+	ResourcePools map[string]int
+	// Scheduling decides which ready stages start first.
+	Scheduling SchedulingPolicy
 }
 
 type Pipeline struct {
-	stages   map[string]Stage
-	results  map[string]*StageResult
-	config   PipelineConfig
-	mu       sync.RWMutex
-	logger   *log.Logger
-	ctx      context.Context
-	cancel   context.CancelFunc
+	stages      map[string]Stage
+	extraDeps   map[string][]string
+	results     map[string]*StageResult
+	config      PipelineConfig
+	mu          sync.RWMutex
+	logger      *slog.Logger
+	ctx         context.Context
+	cancel      context.CancelFunc
+	runID       atomic.Value
+	checkpoints CheckpointStore
+	tracer      *Tracer
+	executing   atomic.Bool
+	paused      atomic.Bool
+	inflight    map[string]context.CancelCauseFunc
+	resources   map[string]int
+	durations   map[string]time.Duration
+	wake        chan struct{}
+	
+	listenersMu    sync.RWMutex
+	listeners      map[int]Listener
+	nextListenerID int
+	
+	checkpointWriter checkpointWriter
 }
 
-func NewPipeline(config PipelineConfig, logger *log.Logger) *Pipeline {
+func NewPipeline(config PipelineConfig, logger *slog.Logger) *Pipeline {
 	if logger == nil {
-		logger = log.Default()
+		logger = slog.Default()
 	}
 	
 	ctx, cancel := context.WithCancel(context.Background())
 	
-	return &Pipeline{
-		stages:  make(map[string]Stage),
-		results: make(map[string]*StageResult),
-		config:  config,
-		logger:  logger,
-		ctx:     ctx,
-		cancel:  cancel,
+	p := &Pipeline{
+		stages:    make(map[string]Stage),
+		extraDeps: make(map[string][]string),
+		results:   make(map[string]*StageResult),
+		config:    config,
+		logger:    logger,
+		ctx:       ctx,
+		cancel:    cancel,
+		inflight:  make(map[string]context.CancelCauseFunc),
+		resources: make(map[string]int),
+		durations: make(map[string]time.Duration),
+		wake:      make(chan struct{}, 1),
 	}
+	p.runID.Store(newRunID())
+	return p
+}
+
+func (p *Pipeline) RunID() string {
+	return p.runID.Load().(string)
+}
+
+// IsRunning reports whether Execute is in progress.
+func (p *Pipeline) IsRunning() bool {
+	return p.executing.Load()
+}
+
+// signal wakes the scheduler of a running pipeline so it picks up stages that
+// became runnable outside of a stage finishing, such as restarted stages.
+func (p *Pipeline) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// log returns the pipeline logger with the current run ID attached.
+func (p *Pipeline) log() *slog.Logger {
+	return p.logger.With("run_id", p.RunID())
 }
 
-func (p *Pipeline) AddStage(stage Stage) {
+// SetCheckpointStore makes the pipeline save every stage state transition
+// to store, under the pipeline's run ID.
+func (p *Pipeline) SetCheckpointStore(store CheckpointStore) *Pipeline {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	
+	p.checkpoints = store
+	return p
+}
+
+// AddStage adds a stage to the pipeline. Stages cannot be added while the
+// pipeline is executing; use Expand instead.
+func (p *Pipeline) AddStage(stage Stage) error {
+	if p.IsRunning() {
+		return fmt.Errorf("cannot add stage %s while the pipeline is executing, use Expand", stage.Name())
+	}
+	
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if stage.Name() == "" {
+		return fmt.Errorf("stage name must not be empty")
+	}
+	if _, exists := p.stages[stage.Name()]; exists {
+		return fmt.Errorf("stage %s already exists", stage.Name())
+	}
+	
 	p.stages[stage.Name()] = stage
 	p.results[stage.Name()] = &StageResult{
 		Status: StatusPending,
 	}
+	return nil
 }
 
 func (p *Pipeline) GetStageResult(name string) (*StageResult, bool) {
@@ -145,6 +329,28 @@ func (p *Pipeline) GetStageResult(name string) (*StageResult, bool) {
 	return result, exists
 }
 
+func (p *Pipeline) snapshotResults() map[string]*StageResult {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
+	results := make(map[string]*StageResult, len(p.results))
+	for name, result := range p.results {
+		snapshot := *result
+		if sub, ok := stageAs[subPipelineStage](p.stages[name]); ok {
+			snapshot.SubStages = sub.Child().snapshotResults()
+		}
+		results[name] = &snapshot
+	}
+	return results
+}
+
+func (p *Pipeline) stageStatus(name string) StageStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
+	return p.results[name].Status
+}
+
 func (p *Pipeline) GetAllResults() map[string]*StageResult {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -156,13 +362,18 @@ func (p *Pipeline) GetAllResults() map[string]*StageResult {
 	return results
 }
 
-func (p *Pipeline) validateDependencies() error {
-	for _, stage := range p.stages {
-		for _, dep := range stage.Dependencies() {
-			if _, exists := p.stages[dep]; !exists {
-				return fmt.Errorf("stage %s depends on non-existent stage %s", stage.Name(), dep)
-			}
-		}
+func (p *Pipeline) Validate() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
+	if err := p.validateGraph(); err != nil {
+		return fmt.Errorf("dependency validation failed: %w", err)
+	}
+	if err := p.validateStageTypes(); err != nil {
+		return fmt.Errorf("stage type validation failed: %w", err)
+	}
+	if err := p.validateResources(); err != nil {
+		return fmt.Errorf("resource validation failed: %w", err)
 	}
 	return nil
 }
@@ -172,46 +383,91 @@ func (p *Pipeline) getExecutableStages() []string {
 	defer p.mu.RUnlock()
 	
 	var executable []string
-	for name, stage := range p.stages {
-		result := p.results[name]
-		if result.Status != StatusPending {
-			continue
-		}
-		
-		canExecute := true
-		for _, dep := range stage.Dependencies() {
-			depResult := p.results[dep]
-			if depResult.Status != StatusCompleted {
-				canExecute = false
-				break
-			}
-		}
-		
-		if canExecute {
+	for name := range p.stages {
+		if p.isReadyLocked(name) {
 			executable = append(executable, name)
 		}
 	}
 	return executable
 }
 
-func (p *Pipeline) executeStageWithRetry(stage Stage, input interface{}) {
+// isReadyLocked reports whether the named stage is pending and all of its
+// dependencies have completed. The caller must hold p.mu.
+func (p *Pipeline) isReadyLocked(name string) bool {
+	if p.results[name].Status != StatusPending {
+		return false
+	}
+	for _, dep := range p.deps(name) {
+		if p.results[dep].Status != StatusCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Pipeline) stageInputs(stage Stage) (interface{}, Inputs) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
+	deps := p.deps(stage.Name())
+	inputs := make(Inputs, len(deps))
+	for _, dep := range deps {
+		if depResult, exists := p.results[dep]; exists {
+			inputs[dep] = depResult.Output
+		}
+	}
+	
+	switch len(deps) {
+	case 0:
+		return nil, inputs
+	case 1:
+		return inputs[deps[0]], inputs
+	default:
+		return inputs, inputs
+	}
+}
+
+func (p *Pipeline) executeStageWithRetry(runCtx context.Context, stage Stage, input interface{}, inputs Inputs) {
 	name := stage.Name()
-	maxRetries := stage.MaxRetries()
+	// Every stage gets at least one attempt, whatever its MaxRetries.
+	maxRetries := max(stage.MaxRetries(), 0)
+	policy := stageRetryPolicy(stage)
+	firstStart := time.Now()
+	var lastDelay time.Duration
+	
+	stageCtx := context.WithValue(runCtx, inputsKey{}, inputs)
+	stageCtx = context.WithValue(stageCtx, expanderKey{}, &Expander{pipeline: p, stage: name})
+	stageCtx, stageSpan := p.tracer.Start(stageCtx, "pipeline.stage")
+	stageSpan.SetAttribute("stage.name", name)
+	var stageErr error
+	defer func() { stageSpan.Finish(stageErr) }()
 	
 	for attempt := 1; attempt <= maxRetries+1; attempt++ {
-		p.logger.Printf("Starting execution of stage: %s (attempt %d/%d)", name, attempt, maxRetries+1)
+		p.log().Info("Starting stage attempt", "stage", name, "attempt", attempt, "max_attempts", maxRetries+1)
 		
 		p.mu.Lock()
 		result := p.results[name]
 		result.Status = StatusRunning
 		result.StartTime = time.Now()
 		result.Attempts = attempt
+		p.checkpointLocked(name)
 		p.mu.Unlock()
+		p.emitStage(EventStageStarted, name, nil, 0)
 		
-		ctx, cancel := context.WithTimeout(p.ctx, stage.Timeout())
+		attemptCtx, attemptSpan := p.tracer.Start(stageCtx, "pipeline.attempt")
+		attemptSpan.SetAttribute("stage.name", name)
+		attemptSpan.SetAttribute("attempt", attempt)
+		
+		ctx, cancel := context.WithTimeout(attemptCtx, stage.Timeout())
 		output, err := stage.Execute(ctx, input)
 		cancel()
 		
+		if err != nil && p.handleInterrupt(runCtx, name, err) {
+			attemptSpan.Finish(err)
+			stageErr = err
+			return
+		}
+		
 		p.mu.Lock()
 		result.EndTime = time.Now()
 		result.Duration = result.EndTime.Sub(result.StartTime)
@@ -220,120 +476,386 @@ func (p *Pipeline) executeStageWithRetry(stage Stage, input interface{}) {
 		
 		if err == nil {
 			result.Status = StatusCompleted
-			p.logger.Printf("Stage %s completed successfully on attempt %d", name, attempt)
+			p.durations[name] = result.Duration
+			p.checkpointLocked(name)
+			p.log().Info("Stage completed", "stage", name, "attempt", attempt, "status", result.Status, "duration", result.Duration)
 			p.mu.Unlock()
+			attemptSpan.Finish(nil)
+			stageErr = nil
+			p.emitStage(EventStageCompleted, name, nil, 0)
 			return
 		}
 		
 		result.Status = StatusFailed
-		p.logger.Printf("Stage %s failed on attempt %d: %v", name, attempt, err)
+		p.checkpointLocked(name)
+		p.log().Warn("Stage attempt failed", "stage", name, "attempt", attempt, "status", result.Status, "duration", result.Duration, "error", err)
+		p.mu.Unlock()
+		p.emitStage(EventAttemptFailed, name, err, 0)
+		stageErr = err
 		
-		if attempt <= maxRetries {
-			p.logger.Printf("Retrying stage %s in %v", name, stage.RetryDelay())
-			p.mu.Unlock()
-			
-			select {
-			case <-time.After(stage.RetryDelay()):
-			case <-p.ctx.Done():
-				p.mu.Lock()
-				result.Error = fmt.Errorf("retry cancelled: %w", p.ctx.Err())
-				p.mu.Unlock()
+		var delay time.Duration
+		retry := false
+		giveUp := "retries exhausted"
+		switch {
+		case IsPermanent(err):
+			giveUp = "error is not retryable"
+		case attempt > maxRetries:
+		default:
+			delay, retry = nextRetryDelay(policy, RetryState{
+				Attempt:   attempt,
+				Elapsed:   time.Since(firstStart),
+				LastDelay: lastDelay,
+				Err:       err,
+			})
+			if !retry {
+				giveUp = "retry budget exhausted"
+			}
+		}
+		
+		if !retry {
+			attemptSpan.Finish(err)
+			p.log().Error("Stage failed permanently", "stage", name, "attempt", attempt, "status", StatusFailed, "reason", giveUp, "error", err)
+			p.emitStage(EventStageFailed, name, err, 0)
+			return
+		}
+		
+		lastDelay = delay
+		attemptSpan.SetAttribute("retry.delay", delay.String())
+		attemptSpan.Finish(err)
+		
+		p.log().Info("Retrying stage", "stage", name, "attempt", attempt, "retry_delay", delay)
+		p.emitStage(EventRetryScheduled, name, err, delay)
+		
+		select {
+		case <-time.After(delay):
+		case <-runCtx.Done():
+			if p.handleInterrupt(runCtx, name, err) {
 				return
 			}
-		} else {
-			p.logger.Printf("Stage %s failed permanently after %d attempts", name, attempt)
+			stageErr = fmt.Errorf("retry cancelled: %w", runCtx.Err())
+			p.mu.Lock()
+			result.Error = stageErr
+			p.checkpointLocked(name)
 			p.mu.Unlock()
+			p.emitStage(EventStageFailed, name, stageErr, 0)
+			return
 		}
 	}
 }
 
+var (
+	ErrAlreadyExecuting = errors.New("pipeline is already executing")
+	// ErrStopped is returned by Execute after Stop, until Reset.
+	ErrStopped = errors.New("pipeline was stopped, reset it to run again")
+)
+
 func (p *Pipeline) Execute() error {
-	if err := p.validateDependencies(); err != nil {
-		return fmt.Errorf("dependency validation failed: %w", err)
+	return p.ExecuteContext(context.Background())
+}
+
+// ExecuteContext is like Execute, but the run is also cancelled when ctx is
+// done, as it is by Stop.
+func (p *Pipeline) ExecuteContext(ctx context.Context) error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	return p.execute(ctx)
+}
+
+// start marks the pipeline as executing if it can run: it is not executing
+// already, has not been stopped and is valid.
+func (p *Pipeline) start() error {
+	if !p.executing.CompareAndSwap(false, true) {
+		return ErrAlreadyExecuting
 	}
 	
-	p.logger.Println("Starting pipeline execution")
+	var err error
+	if p.stopContext().Err() != nil {
+		err = ErrStopped
+	} else {
+		err = p.Validate()
+	}
+	if err != nil {
+		p.executing.Store(false)
+	}
+	return err
+}
+
+// execute runs a pipeline that start has marked as executing.
+func (p *Pipeline) execute(ctx context.Context) error {
+	defer p.executing.Store(false)
+	
+	p.loadStageDurations()
 	
+	runID := p.RunID()
+	p.log().Info("Starting pipeline execution")
+	p.emit(Event{Type: EventPipelineStarted, RunID: runID})
+	
+	// Stop cancels the run through p.ctx. AfterFunc calls cancel in its own
+	// goroutine, so a pipeline stopped before the run starts is cancelled
+	// here, before any stage can launch.
+	stopCtx := p.stopContext()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(stopCtx, cancel)()
+	if stopCtx.Err() != nil {
+		cancel()
+	}
+	
+	ctx, span := p.tracer.Start(ctx, "pipeline.run")
+	span.SetAttribute("pipeline.run_id", runID)
+	err := p.run(ctx)
+	span.Finish(err)
+	
+	// The run is only finished once its checkpoints are saved, for a process
+	// that exits when Execute returns.
+	p.checkpointWriter.wait()
+	
+	// Listeners of the finished event see the pipeline as no longer running.
+	p.executing.Store(false)
+	p.emit(Event{Type: EventPipelineFinished, RunID: runID, Err: err})
+	return err
+}
+
+func (p *Pipeline) run(ctx context.Context) error {
 	if p.config.GlobalTimeout > 0 {
 		var cancel context.CancelFunc
-		p.ctx, cancel = context.WithTimeout(p.ctx, p.config.GlobalTimeout)
+		ctx, cancel = context.WithTimeout(ctx, p.config.GlobalTimeout)
 		defer cancel()
 	}
 	
-	semaphore := make(chan struct{}, p.config.MaxConcurrency)
-	var wg sync.WaitGroup
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	
+	p.skipBlockedStages()
+	
+	maxConcurrency := p.config.MaxConcurrency
+	done := make(chan string)
+	ctxDone := ctx.Done()
+	running := 0
+	stopped := false
+	failFastStage := ""
+	var failed []string
 	
 	for {
-		select {
-		case <-p.ctx.Done():
-			return fmt.Errorf("pipeline execution cancelled: %w", p.ctx.Err())
-		default:
+		paused := p.IsPaused()
+		if !stopped && !paused && ctx.Err() == nil {
+			slots := -1
+			if maxConcurrency > 0 {
+				slots = maxConcurrency - running
+			}
+			running += p.launchReadyStages(runCtx, slots, done)
 		}
 		
-		executable := p.getExecutableStages()
-		if len(executable) == 0 {
+		// A paused pipeline waits for Resume, unless it cannot continue anyway.
+		if running == 0 && (!paused || stopped || ctx.Err() != nil) {
 			break
 		}
 		
-		for _, stageName := range executable {
-			stage := p.stages[stageName]
+		select {
+		case name := <-done:
+			running--
+			status := p.stageStatus(name)
+			if status != StatusFailed && status != StatusCancelled {
+				continue
+			}
 			
-			wg.Add(1)
-			go func(s Stage) {
-				defer wg.Done()
-				
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
-				
-				var input interface{}
-				if len(s.Dependencies()) > 0 {
-					dep := s.Dependencies()[0]
-					if depResult, exists := p.results[dep]; exists {
-						input = depResult.Output
-					}
+			failed = append(failed, name)
+			p.skipBlockedStages()
+			
+			switch {
+			// A cancelled stage stops the run like a failed one, but does not
+			// abort the other running stages.
+			case p.config.FailFast && status == StatusFailed:
+				if failFastStage == "" {
+					p.log().Warn("Stage failed, cancelling running stages (fail-fast mode)", "stage", name)
+					failFastStage = name
+					stopped = true
+					cancelRun()
 				}
-				
-				p.executeStageWithRetry(s, input)
-			}(stage)
+			case !p.config.ContinueOnFailure:
+				if !stopped {
+					p.log().Warn("Stage failed, waiting for running stages before stopping", "stage", name)
+					stopped = true
+				}
+			}
+		case <-p.wake:
+		case <-ctxDone:
+			ctxDone = nil
 		}
+	}
+	
+	if failFastStage != "" {
+		return fmt.Errorf("pipeline execution stopped due to failure of stage %s (fail-fast mode)", failFastStage)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("pipeline execution cancelled: %w", err)
+	}
+	if stopped {
+		return fmt.Errorf("pipeline execution stopped due to failed stages: %s", strings.Join(failed, ", "))
+	}
+	
+	p.log().Info("Pipeline execution completed")
+	return nil
+}
+
+// checkConditions skips the conditional stages among ready whose condition
+// does not hold, and returns the stages that may run.
+func (p *Pipeline) checkConditions(ready []string) []string {
+	var results map[string]*StageResult
+	runnable := ready[:0]
+	skipped := false
+	
+	for _, name := range ready {
+		p.mu.RLock()
+		stage := p.stages[name]
+		p.mu.RUnlock()
 		
-		wg.Wait()
+		conditional, ok := stageAs[conditionalStage](stage)
+		if !ok || conditional.Condition() == nil {
+			runnable = append(runnable, name)
+			continue
+		}
 		
-		if p.config.FailFast && p.hasFailures() {
-			return fmt.Errorf("pipeline execution stopped due to failures (fail-fast mode)")
+		if results == nil {
+			results = p.snapshotResults()
+		}
+		if conditional.Condition()(results) {
+			runnable = append(runnable, name)
+			continue
 		}
 		
-		if p.isComplete() {
-			break
+		p.mu.Lock()
+		result := p.results[name]
+		// The stage may have changed since it was found ready, for example
+		// restarted or given a new dependency by Expand.
+		if !p.isReadyLocked(name) {
+			p.mu.Unlock()
+			continue
 		}
+		result.Status = StatusSkipped
+		result.SkipReason = fmt.Sprintf("condition of stage %s not met", name)
+		p.checkpointLocked(name)
+		p.mu.Unlock()
+		p.log().Info("Skipping stage", "stage", name, "status", StatusSkipped, "reason", result.SkipReason)
+		p.emitStage(EventStageSkipped, name, nil, 0)
+		skipped = true
 	}
 	
-	p.logger.Println("Pipeline execution completed")
-	return nil
+	if skipped {
+		p.skipBlockedStages()
+	}
+	return runnable
 }
 
-func (p *Pipeline) hasFailures() bool {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+// skipBlockedStages marks every pending stage that depends, directly or
+// transitively, on a failed or skipped stage as skipped. The skip reason
+// names the failed or skipped ancestor.
+func (p *Pipeline) skipBlockedStages() {
+	var skipped []string
+	p.mu.Lock()
+	for changed := true; changed; {
+		changed = false
+		for _, name := range p.stageNames() {
+			result := p.results[name]
+			if result.Status != StatusPending {
+				continue
+			}
+			
+			for _, dep := range p.deps(name) {
+				depResult := p.results[dep]
+				var reason string
+				switch depResult.Status {
+				case StatusFailed:
+					reason = fmt.Sprintf("upstream stage %s failed", dep)
+				case StatusCancelled:
+					reason = fmt.Sprintf("upstream stage %s was cancelled", dep)
+				case StatusSkipped:
+					reason = depResult.SkipReason
+				default:
+					continue
+				}
+				
+				result.Status = StatusSkipped
+				result.SkipReason = reason
+				p.checkpointLocked(name)
+				p.log().Info("Skipping stage", "stage", name, "status", StatusSkipped, "reason", reason)
+				skipped = append(skipped, name)
+				changed = true
+				break
+			}
+		}
+	}
+	p.mu.Unlock()
 	
-	for _, result := range p.results {
-		if result.Status == StatusFailed {
-			return true
+	for _, name := range skipped {
+		p.emitStage(EventStageSkipped, name, nil, 0)
+	}
+}
+
+// launchReadyStages starts up to slots stages whose dependencies have all
+// completed and whose resources are available, or every such stage if slots
+// is negative. Each started stage sends its name on done when it finishes.
+func (p *Pipeline) launchReadyStages(ctx context.Context, slots int, done chan<- string) int {
+	if slots == 0 {
+		return 0
+	}
+	
+	ready := p.orderStages(p.checkConditions(p.getExecutableStages()))
+	
+	p.mu.Lock()
+	stages := make([]Stage, 0, len(ready))
+	contexts := make([]context.Context, 0, len(ready))
+	for _, name := range ready {
+		if slots > 0 && len(stages) == slots {
+			break
+		}
+		// The lock was released since the stage was found ready, so a
+		// restart, a skip or Expand may have changed it in between.
+		if !p.isReadyLocked(name) {
+			continue
+		}
+		if !p.acquireResourcesLocked(p.stages[name]) {
+			continue
 		}
+		
+		stageCtx, cancel := context.WithCancelCause(ctx)
+		p.results[name].Status = StatusRunning
+		p.inflight[name] = cancel
+		stages = append(stages, p.stages[name])
+		contexts = append(contexts, stageCtx)
 	}
-	return false
+	p.mu.Unlock()
+	
+	for i, stage := range stages {
+		go func(stageCtx context.Context, s Stage) {
+			input, inputs := p.stageInputs(s)
+			if len(inputs) == 0 {
+				input = stageCtx.Value(pipelineInputKey{})
+			}
+			p.executeStageWithRetry(stageCtx, s, input, inputs)
+			
+			p.mu.Lock()
+			p.inflight[s.Name()](nil)
+			delete(p.inflight, s.Name())
+			p.releaseResourcesLocked(s)
+			p.mu.Unlock()
+			done <- s.Name()
+		}(contexts[i], stage)
+	}
+	return len(stages)
 }
 
-func (p *Pipeline) isComplete() bool {
+func (p *Pipeline) hasFailures() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	
 	for _, result := range p.results {
-		if result.Status == StatusPending || result.Status == StatusRunning {
-			return false
+		if result.Status == StatusFailed || result.Status == StatusCancelled {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 func (p *Pipeline) RestartFailedStages() error {
@@ -342,20 +864,25 @@ func (p *Pipeline) RestartFailedStages() error {
 	
 	restarted := 0
 	for name, result := range p.results {
-		if result.Status == StatusFailed {
-			p.logger.Printf("Restarting failed stage: %s", name)
-			result.Status = StatusPending
-			result.Error = nil
-			result.Output = nil
-			result.Attempts = 0
-			result.StartTime = time.Time{}
-			result.EndTime = time.Time{}
-			result.Duration = 0
+		failed := result.Status == StatusFailed || result.Status == StatusCancelled
+		if _, running := p.inflight[name]; failed && !running {
+			p.log().Info("Restarting failed stage", "stage", name)
+			resetResult(result)
+			p.checkpointLocked(name)
 			restarted++
+			
+			for _, depStage := range p.getDependentStages(name) {
+				if depResult := p.results[depStage]; depResult.Status == StatusSkipped {
+					p.log().Info("Restarting skipped stage", "stage", depStage)
+					resetResult(depResult)
+					p.checkpointLocked(depStage)
+				}
+			}
 		}
 	}
 	
-	p.logger.Printf("Restarted %d failed stages", restarted)
+	p.log().Info("Restarted failed stages", "count", restarted)
+	p.signal()
 	return nil
 }
 
@@ -368,7 +895,28 @@ func (p *Pipeline) RestartStage(stageName string) error {
 		return fmt.Errorf("stage %s not found", stageName)
 	}
 	
-	p.logger.Printf("Restarting stage: %s", stageName)
+	dependentStages := p.getDependentStages(stageName)
+	for _, name := range append([]string{stageName}, dependentStages...) {
+		if _, running := p.inflight[name]; running {
+			return fmt.Errorf("cannot restart stage %s: stage %s is running", stageName, name)
+		}
+	}
+	
+	p.log().Info("Restarting stage", "stage", stageName)
+	resetResult(result)
+	p.checkpointLocked(stageName)
+	
+	for _, depStage := range dependentStages {
+		p.log().Info("Restarting dependent stage", "stage", depStage)
+		resetResult(p.results[depStage])
+		p.checkpointLocked(depStage)
+	}
+	
+	p.signal()
+	return nil
+}
+
+func resetResult(result *StageResult) {
 	result.Status = StatusPending
 	result.Error = nil
 	result.Output = nil
@@ -376,67 +924,114 @@ func (p *Pipeline) RestartStage(stageName string) error {
 	result.StartTime = time.Time{}
 	result.EndTime = time.Time{}
 	result.Duration = 0
-	
-	dependentStages := p.getDependentStages(stageName)
-	for _, depStage := range dependentStages {
-		p.logger.Printf("Restarting dependent stage: %s", depStage)
-		depResult := p.results[depStage]
-		depResult.Status = StatusPending
-		depResult.Error = nil
-		depResult.Output = nil
-		depResult.Attempts = 0
-		depResult.StartTime = time.Time{}
-		depResult.EndTime = time.Time{}
-		depResult.Duration = 0
-	}
-	
-	return nil
+	result.SkipReason = ""
 }
 
 func (p *Pipeline) getDependentStages(stageName string) []string {
 	var dependents []string
-	for name, stage := range p.stages {
-		for _, dep := range stage.Dependencies() {
-			if dep == stageName {
-				dependents = append(dependents, name)
-				dependents = append(dependents, p.getDependentStages(name)...)
+	seen := map[string]bool{stageName: true}
+	queue := []string{stageName}
+	
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		
+		for _, name := range p.stageNames() {
+			if seen[name] {
+				continue
+			}
+			for _, dep := range p.deps(name) {
+				if dep == current {
+					seen[name] = true
+					dependents = append(dependents, name)
+					queue = append(queue, name)
+					break
+				}
 			}
 		}
 	}
 	return dependents
 }
 
-func (p *Pipeline) Reset() {
+// Reset discards all stage results and starts a new run ID. A stopped
+// pipeline can run again after Reset. It fails while the pipeline is
+// executing.
+func (p *Pipeline) Reset() error {
+	if p.IsRunning() {
+		return fmt.Errorf("cannot reset while the pipeline is executing")
+	}
+	
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	
-	p.logger.Println("Resetting pipeline")
+	p.log().Info("Resetting pipeline")
+	p.runID.Store(newRunID())
+	if p.ctx.Err() != nil {
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+	}
 	for name := range p.results {
 		p.results[name] = &StageResult{
 			Status: StatusPending,
 		}
 	}
+	return nil
 }
 
 func (p *Pipeline) Stop() {
-	p.logger.Println("Stopping pipeline")
+	p.log().Info("Stopping pipeline")
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
 	p.cancel()
 }
 
-func (p *Pipeline) PrintStatus() {
+// stopContext returns the context that Stop cancels.
+func (p *Pipeline) stopContext() context.Context {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	
-	fmt.Println("\n=== Pipeline Status ===")
-	for name, result := range p.results {
-		fmt.Printf("Stage: %-20s Status: %-10s Attempts: %d", name, result.Status, result.Attempts)
+	return p.ctx
+}
+
+func (p *Pipeline) PrintStatus() {
+	writeStatus(os.Stdout, p.snapshotResults())
+}
+
+func writeStatus(w io.Writer, results map[string]*StageResult) {
+	results = flattenSubStages(results)
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	
+	fmt.Fprintln(w, "\n=== Pipeline Status ===")
+	for _, name := range names {
+		result := results[name]
+		fmt.Fprintf(w, "Stage: %-20s Status: %-10s Attempts: %d", name, result.Status, result.Attempts)
 		if result.Duration > 0 {
-			fmt.Printf(" Duration: %v", result.Duration)
+			fmt.Fprintf(w, " Duration: %v", result.Duration)
 		}
 		if result.Error != nil {
-			fmt.Printf(" Error: %v", result.Error)
+			fmt.Fprintf(w, " Error: %v", result.Error)
+		}
+		if result.SkipReason != "" {
+			fmt.Fprintf(w, " Reason: %s", result.SkipReason)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "=====================")
+}
+
+// flattenSubStages adds the sub-stages of results to the map, named
+// parent/child, so that they are listed under their parent stage.
+func flattenSubStages(results map[string]*StageResult) map[string]*StageResult {
+	flat := make(map[string]*StageResult, len(results))
+	for name, result := range results {
+		flat[name] = result
+		for subName, subResult := range flattenSubStages(result.SubStages) {
+			flat[name+"/"+subName] = subResult
 		}
-		fmt.Println()
 	}
-	fmt.Println("=====================")
-}
\ No newline at end of file
+	return flat
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testStage struct {
+	*BaseStage
+	fn func(ctx context.Context, input interface{}) (interface{}, error)
+}
+
+func (s *testStage) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+	return s.fn(ctx, input)
+}
+
+// newTestStage returns a stage without retries that runs fn.
+func newTestStage(name string, deps []string, fn func(ctx context.Context, input interface{}) (interface{}, error)) *testStage {
+	return &testStage{
+		BaseStage: NewBaseStage(name, deps).SetMaxRetries(0).SetRetryDelay(time.Millisecond),
+		fn:        fn,
+	}
+}
+
+// sleepStage returns fn for a stage that outputs its name after d, or fails
+// when its context is cancelled first.
+func sleepStage(name string, d time.Duration) func(ctx context.Context, input interface{}) (interface{}, error) {
+	return func(ctx context.Context, input interface{}) (interface{}, error) {
+		select {
+		case <-time.After(d):
+			return name, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func failStage(ctx context.Context, input interface{}) (interface{}, error) {
+	return nil, errors.New("boom")
+}
+
+func newTestPipeline(t *testing.T, config PipelineConfig, stages ...Stage) *Pipeline {
+	t.Helper()
+	p := NewPipeline(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, stage := range stages {
+		if err := p.AddStage(stage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func assertStatuses(t *testing.T, p *Pipeline, want map[string]StageStatus) {
+	t.Helper()
+	for name, status := range want {
+		result, ok := p.GetStageResult(name)
+		if !ok {
+			t.Errorf("stage %s not found", name)
+			continue
+		}
+		if result.Status != status {
+			t.Errorf("stage %s is %s, want %s (error: %v)", name, result.Status, status, result.Error)
+		}
+	}
+}
+
+func TestSchedulerRunsStagesAfterTheirDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+	}{
+		{"chain", map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}}},
+		{"diamond", map[string][]string{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}}},
+		{"wide", map[string][]string{"a": nil, "b": nil, "c": nil, "d": {"a", "b", "c"}, "e": {"d"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPipeline(t, PipelineConfig{MaxConcurrency: 2})
+			for name, deps := range tt.graph {
+				p.AddStage(newTestStage(name, deps, sleepStage(name, 5*time.Millisecond)))
+			}
+
+			if err := p.Execute(); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			for name, deps := range tt.graph {
+				result, _ := p.GetStageResult(name)
+				if result.Status != StatusCompleted {
+					t.Fatalf("stage %s is %s", name, result.Status)
+				}
+				for _, dep := range deps {
+					depResult, _ := p.GetStageResult(dep)
+					if result.StartTime.Before(depResult.EndTime) {
+						t.Errorf("stage %s started before its dependency %s ended", name, dep)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerDoesNotWaitForUnrelatedStages(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("slow", nil, sleepStage("slow", 200*time.Millisecond)),
+		newTestStage("fast", nil, sleepStage("fast", time.Millisecond)),
+		newTestStage("next", []string{"fast"}, sleepStage("next", time.Millisecond)),
+	)
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	slow, _ := p.GetStageResult("slow")
+	next, _ := p.GetStageResult("next")
+	if !next.EndTime.Before(slow.EndTime) {
+		t.Errorf("stage next waited for the unrelated stage slow")
+	}
+}
+
+func TestSchedulerRespectsMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		maxConcurrency int
+		wantPeak       int32
+	}{
+		{1, 1},
+		{2, 2},
+		{0, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("max_%d", tt.maxConcurrency), func(t *testing.T) {
+			var running, peak atomic.Int32
+			p := newTestPipeline(t, PipelineConfig{MaxConcurrency: tt.maxConcurrency})
+			for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+				p.AddStage(newTestStage(name, nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+					n := running.Add(1)
+					defer running.Add(-1)
+					for old := peak.Load(); n > old && !peak.CompareAndSwap(old, n); old = peak.Load() {
+					}
+					time.Sleep(20 * time.Millisecond)
+					return nil, nil
+				}))
+			}
+
+			if err := p.Execute(); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got := peak.Load(); got != tt.wantPeak {
+				t.Errorf("peak concurrency is %d, want %d", got, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestSchedulerFailureModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PipelineConfig
+		wantErr string
+		want    map[string]StageStatus
+	}{
+		{
+			name:    "fail fast cancels running stages",
+			config:  PipelineConfig{FailFast: true},
+			wantErr: "fail-fast mode",
+			want: map[string]StageStatus{
+				"bad": StatusFailed, "slow": StatusFailed, "after_slow": StatusSkipped, "after_bad": StatusSkipped,
+			},
+		},
+		{
+			name:    "default waits for running stages and starts no new ones",
+			config:  PipelineConfig{},
+			wantErr: "stopped due to failed stages: bad",
+			want: map[string]StageStatus{
+				"bad": StatusFailed, "slow": StatusCompleted, "after_slow": StatusPending, "after_bad": StatusSkipped,
+			},
+		},
+		{
+			name:    "continue on failure runs the other branches",
+			config:  PipelineConfig{ContinueOnFailure: true},
+			wantErr: "",
+			want: map[string]StageStatus{
+				"bad": StatusFailed, "slow": StatusCompleted, "after_slow": StatusCompleted, "after_bad": StatusSkipped,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPipeline(t, tt.config,
+				newTestStage("bad", nil, failStage),
+				newTestStage("slow", nil, sleepStage("slow", 100*time.Millisecond)),
+				newTestStage("after_slow", []string{"slow"}, sleepStage("after_slow", time.Millisecond)),
+				newTestStage("after_bad", []string{"bad"}, sleepStage("after_bad", time.Millisecond)),
+			)
+
+			err := p.Execute()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Execute: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Execute returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			assertStatuses(t, p, tt.want)
+		})
+	}
+}
+
+func TestSchedulerPassesOutputsToDependents(t *testing.T) {
+	var got Inputs
+	var mu sync.Mutex
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("a", nil, sleepStage("a", 0)),
+		newTestStage("b", nil, sleepStage("b", 0)),
+		newTestStage("c", []string{"a", "b"}, func(ctx context.Context, input interface{}) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = input.(Inputs)
+			return nil, nil
+		}),
+	)
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got["a"] != "a" || got["b"] != "b" {
+		t.Errorf("stage c got inputs %v", got)
+	}
+}
+
+func TestExecuteAfterStopLaunchesNothing(t *testing.T) {
+	var ran atomic.Bool
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("a", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			ran.Store(true)
+			return nil, nil
+		}),
+	)
+	p.Stop()
+
+	if err := p.Execute(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Execute returned %v, want ErrStopped", err)
+	}
+	if ran.Load() {
+		t.Errorf("a stage ran after Stop")
+	}
+
+	if err := p.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if err := p.Execute(); err != nil || !ran.Load() {
+		t.Errorf("Execute after Reset returned %v, stage ran: %v", err, ran.Load())
+	}
+}
+
+func TestPipelineStageTracesIntoParentTrace(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	child := newTestPipeline(t, PipelineConfig{}, newTestStage("inner", nil, sleepStage("inner", 0)))
+	p := newTestPipeline(t, PipelineConfig{}, NewPipelineStage("child", nil, child))
+	p.SetTracer(NewTracer(exporter))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	spans := exporter.Spans()
+	var inner bool
+	for _, span := range spans {
+		if span.TraceID != spans[0].TraceID {
+			t.Errorf("span %s is in trace %s, want %s", span.Name, span.TraceID, spans[0].TraceID)
+		}
+		inner = inner || span.Attributes["stage.name"] == "inner"
+	}
+	if !inner {
+		t.Errorf("no span for the child stage inner")
+	}
+}
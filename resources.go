@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+)
+
+type resourceStage interface {
+	Resources() map[string]int
+}
+
+// stageResources returns the resource units stage requires, by pool name.
+func stageResources(stage Stage) map[string]int {
+	if s, ok := stageAs[resourceStage](stage); ok {
+		return s.Resources()
+	}
+	return nil
+}
+
+func (p *Pipeline) validateResources() error {
+	for _, pool := range sortedKeys(p.config.ResourcePools) {
+		if capacity := p.config.ResourcePools[pool]; capacity <= 0 {
+			return fmt.Errorf("resource pool %s has capacity %d, must be positive", pool, capacity)
+		}
+	}
+
+	for _, name := range p.stageNames() {
+		required := stageResources(p.stages[name])
+		for _, pool := range sortedKeys(required) {
+			amount := required[pool]
+			capacity, defined := p.config.ResourcePools[pool]
+			switch {
+			case amount <= 0:
+				return fmt.Errorf("stage %s requires %d units of resource %s, must be positive", name, amount, pool)
+			case !defined:
+				return fmt.Errorf("stage %s requires undefined resource pool %s", name, pool)
+			case amount > capacity:
+				return fmt.Errorf("stage %s requires %d units of resource %s, more than its capacity %d", name, amount, pool, capacity)
+			}
+		}
+	}
+	return nil
+}
+
+// acquireResourcesLocked reserves the resources stage requires if they are
+// all free, and reports whether it did.
+func (p *Pipeline) acquireResourcesLocked(stage Stage) bool {
+	required := stageResources(stage)
+	for pool, amount := range required {
+		if p.resources[pool]+amount > p.config.ResourcePools[pool] {
+			return false
+		}
+	}
+	for pool, amount := range required {
+		p.resources[pool] += amount
+	}
+	return true
+}
+
+func (p *Pipeline) releaseResourcesLocked(stage Stage) {
+	for pool, amount := range stageResources(stage) {
+		p.resources[pool] -= amount
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResourcePoolsLimitConcurrentStages(t *testing.T) {
+	var holding, peak atomic.Int32
+	useDB := func(ctx context.Context, input interface{}) (interface{}, error) {
+		n := holding.Add(2)
+		defer holding.Add(-2)
+		for old := peak.Load(); n > old && !peak.CompareAndSwap(old, n); old = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	}
+
+	p := newTestPipeline(t, PipelineConfig{ResourcePools: map[string]int{"db": 3}})
+	for _, name := range []string{"a", "b", "c"} {
+		stage := newTestStage(name, nil, useDB)
+		stage.Require("db", 2)
+		p.AddStage(stage)
+	}
+	p.AddStage(newTestStage("free", nil, sleepStage("free", 20*time.Millisecond)))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak db usage is %d, want 2", got)
+	}
+	free, _ := p.GetStageResult("free")
+	var lastStart time.Time
+	for _, name := range []string{"a", "b", "c"} {
+		if result, _ := p.GetStageResult(name); result.StartTime.After(lastStart) {
+			lastStart = result.StartTime
+		}
+	}
+	if !free.StartTime.Before(lastStart) {
+		t.Errorf("stage without resources waited for the pool")
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.resources["db"] != 0 {
+		t.Errorf("%d db units are still held after the run", p.resources["db"])
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		pools   map[string]int
+		amount  int
+		wantErr string
+	}{
+		{"fits", map[string]int{"db": 2}, 2, ""},
+		{"undefined pool", nil, 1, "undefined resource pool db"},
+		{"over capacity", map[string]int{"db": 1}, 2, "more than its capacity"},
+		{"non-positive amount", map[string]int{"db": 1}, 0, "must be positive"},
+		{"non-positive capacity", map[string]int{"db": 0}, 1, "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stage := newTestStage("a", nil, sleepStage("a", 0))
+			stage.Require("db", tt.amount)
+			p := newTestPipeline(t, PipelineConfig{ResourcePools: tt.pools}, stage)
+
+			err := p.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
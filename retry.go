@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+type RetryState struct {
+	Attempt   int           // number of the attempt that just failed, from 1
+	Elapsed   time.Duration // time since the first attempt started
+	LastDelay time.Duration // delay before the failed attempt, 0 for the first
+	Err       error
+}
+
+// RetryPolicy decides how long to wait before the next attempt of a failed
+// stage. Returning false gives up even if the stage has retries left.
+type RetryPolicy interface {
+	NextDelay(state RetryState) (time.Duration, bool)
+}
+
+type FixedDelay struct {
+	Delay time.Duration
+}
+
+func (f FixedDelay) NextDelay(state RetryState) (time.Duration, bool) {
+	return f.Delay, true
+}
+
+type JitterMode int
+
+const (
+	NoJitter JitterMode = iota
+	FullJitter
+	DecorrelatedJitter
+)
+
+type ExponentialBackoff struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       JitterMode
+}
+
+func NewExponentialBackoff(initial, max time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		InitialDelay: initial,
+		MaxDelay:     max,
+		Multiplier:   2,
+	}
+}
+
+func (b *ExponentialBackoff) SetJitter(jitter JitterMode) *ExponentialBackoff {
+	b.Jitter = jitter
+	return b
+}
+
+func (b *ExponentialBackoff) NextDelay(state RetryState) (time.Duration, bool) {
+	var delay time.Duration
+
+	switch b.Jitter {
+	case DecorrelatedJitter:
+		// sleep = random_between(initial, previous sleep * 3)
+		prev := state.LastDelay
+		if prev < b.InitialDelay {
+			prev = b.InitialDelay
+		}
+		upper := b.capDelay(float64(prev) * 3)
+		delay = b.InitialDelay + randomDuration(upper-b.InitialDelay)
+	default:
+		multiplier := b.Multiplier
+		if multiplier < 1 {
+			multiplier = 1
+		}
+		delay = b.capDelay(float64(b.InitialDelay) * math.Pow(multiplier, float64(state.Attempt-1)))
+		if b.Jitter == FullJitter {
+			delay = randomDuration(delay)
+		}
+	}
+
+	return b.capDelay(float64(delay)), true
+}
+
+func (b *ExponentialBackoff) capDelay(delay float64) time.Duration {
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		return b.MaxDelay
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	if max == math.MaxInt64 {
+		// max+1 would overflow.
+		return time.Duration(rand.Int63())
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+type retryBudget struct {
+	policy RetryPolicy
+	budget time.Duration
+}
+
+// WithRetryBudget stops retrying once the time since the first attempt plus
+// the next delay would exceed budget.
+func WithRetryBudget(policy RetryPolicy, budget time.Duration) RetryPolicy {
+	return &retryBudget{policy: policy, budget: budget}
+}
+
+func (r *retryBudget) NextDelay(state RetryState) (time.Duration, bool) {
+	delay, ok := nextRetryDelay(r.policy, state)
+	if !ok || state.Elapsed+delay > r.budget {
+		return 0, false
+	}
+	return delay, true
+}
+
+// nextRetryDelay asks policy for the delay before the next attempt, raised to
+// the RetryAfter hint of the failed attempt's error if that is longer.
+func nextRetryDelay(policy RetryPolicy, state RetryState) (time.Duration, bool) {
+	delay, ok := policy.NextDelay(state)
+	if !ok {
+		return 0, false
+	}
+	if hint, ok := retryAfterDelay(state.Err); ok && hint > delay {
+		delay = hint
+	}
+	return delay, true
+}
+
+type retryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+func stageRetryPolicy(stage Stage) RetryPolicy {
+	if provider, ok := stageAs[retryPolicyProvider](stage); ok {
+		if policy := provider.RetryPolicy(); policy != nil {
+			return policy
+		}
+	}
+	return FixedDelay{Delay: stage.RetryDelay()}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterCountsAgainstRetryBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		hint         time.Duration
+		wantAttempts int
+	}{
+		{"hint within budget", 10 * time.Millisecond, 3},
+		{"hint past budget", 2 * time.Second, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stage := newTestStage("flaky", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+				return nil, RetryAfter(errors.New("busy"), tt.hint)
+			})
+			stage.SetMaxRetries(2).SetRetryBudget(100 * time.Millisecond)
+			p := newTestPipeline(t, PipelineConfig{}, stage)
+
+			start := time.Now()
+			p.Execute()
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("stage waited %s for a hint past its retry budget", elapsed)
+			}
+			result, _ := p.GetStageResult("flaky")
+			if result.Attempts != tt.wantAttempts {
+				t.Errorf("stage made %d attempts, want %d", result.Attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		state     RetryState
+		wantDelay time.Duration
+		wantRetry bool
+		// maxDelay, when set, accepts any delay from wantDelay to maxDelay.
+		maxDelay time.Duration
+	}{
+		{"fixed delay", FixedDelay{Delay: time.Second}, RetryState{Attempt: 3}, time.Second, true, 0},
+		{"exponential", NewExponentialBackoff(time.Second, time.Minute), RetryState{Attempt: 3}, 4 * time.Second, true, 0},
+		{"exponential capped", NewExponentialBackoff(time.Second, 3*time.Second), RetryState{Attempt: 3}, 3 * time.Second, true, 0},
+		{"within budget", WithRetryBudget(FixedDelay{Delay: time.Second}, 5*time.Second), RetryState{Elapsed: 3 * time.Second}, time.Second, true, 0},
+		{"past budget", WithRetryBudget(FixedDelay{Delay: time.Second}, 5*time.Second), RetryState{Elapsed: 4500 * time.Millisecond}, 0, false, 0},
+		{
+			"hint past budget",
+			WithRetryBudget(FixedDelay{Delay: time.Second}, 5*time.Second),
+			RetryState{Elapsed: time.Second, Err: RetryAfter(errors.New("busy"), 10*time.Second)},
+			0, false, 0,
+		},
+		{
+			"full jitter",
+			NewExponentialBackoff(time.Second, time.Minute).SetJitter(FullJitter),
+			RetryState{Attempt: 3},
+			0, true, 4 * time.Second,
+		},
+		{
+			"full jitter without max delay",
+			NewExponentialBackoff(time.Second, 0).SetJitter(FullJitter),
+			RetryState{Attempt: 35},
+			0, true, math.MaxInt64,
+		},
+		{
+			"decorrelated jitter",
+			NewExponentialBackoff(time.Second, time.Minute).SetJitter(DecorrelatedJitter),
+			RetryState{LastDelay: 2 * time.Second},
+			time.Second, true, 6 * time.Second,
+		},
+		{
+			"decorrelated jitter without max delay",
+			NewExponentialBackoff(0, 0).SetJitter(DecorrelatedJitter),
+			RetryState{LastDelay: math.MaxInt64},
+			0, true, math.MaxInt64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := nextRetryDelay(tt.policy, tt.state)
+			if tt.maxDelay > 0 {
+				if delay < tt.wantDelay || delay > tt.maxDelay || retry != tt.wantRetry {
+					t.Errorf("got (%s, %v), want (%s to %s, %v)", delay, retry, tt.wantDelay, tt.maxDelay, tt.wantRetry)
+				}
+				return
+			}
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("got (%s, %v), want (%s, %v)", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
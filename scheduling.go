@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SchedulingPolicy decides which ready stages start first when there are
+// more of them than free concurrency slots or resources.
+type SchedulingPolicy int
+
+const (
+	// SchedulePriority starts stages by descending priority, then by name.
+	SchedulePriority SchedulingPolicy = iota
+	// ScheduleCriticalPath starts first the stages with the longest
+	// remaining path to the end of the pipeline, estimated from the
+	// durations of previous runs, then falls back to SchedulePriority.
+	ScheduleCriticalPath
+)
+
+func (s SchedulingPolicy) String() string {
+	switch s {
+	case SchedulePriority:
+		return "priority"
+	case ScheduleCriticalPath:
+		return "critical_path"
+	default:
+		return "unknown"
+	}
+}
+
+func (s SchedulingPolicy) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *SchedulingPolicy) UnmarshalText(text []byte) error {
+	for policy := SchedulePriority; policy <= ScheduleCriticalPath; policy++ {
+		if policy.String() == string(text) {
+			*s = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown scheduling policy %q, want priority or critical_path", text)
+}
+
+// defaultStageEstimate is the estimated duration of every stage when no
+// stage has a recorded duration yet, which makes the critical path the one
+// with the most stages.
+const defaultStageEstimate = time.Second
+
+type prioritizedStage interface {
+	Priority() int
+}
+
+func stagePriority(stage Stage) int {
+	if s, ok := stageAs[prioritizedStage](stage); ok {
+		return s.Priority()
+	}
+	return 0
+}
+
+// SetStageDurations seeds the durations used by ScheduleCriticalPath, for
+// example with the results of an earlier run. The pipeline also records the
+// duration of every stage that completes.
+func (p *Pipeline) SetStageDurations(durations map[string]time.Duration) *Pipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, d := range durations {
+		p.durations[name] = d
+	}
+	return p
+}
+
+// durationHistory is implemented by checkpoint stores that know the stage
+// durations of earlier runs, such as FileCheckpointStore.
+type durationHistory interface {
+	StageDurations(runs int) (map[string]time.Duration, error)
+}
+
+// durationHistoryRuns is how many earlier runs loadStageDurations looks at.
+const durationHistoryRuns = 20
+
+// loadStageDurations seeds the durations used by ScheduleCriticalPath from
+// the earlier runs in the checkpoint store, for the stages that have none
+// yet, so that a new process does not start without history.
+func (p *Pipeline) loadStageDurations() {
+	if p.config.Scheduling != ScheduleCriticalPath {
+		return
+	}
+	p.mu.RLock()
+	history, ok := p.checkpoints.(durationHistory)
+	p.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	durations, err := history.StageDurations(durationHistoryRuns)
+	if err != nil {
+		p.log().Warn("Cannot load stage durations of earlier runs", "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, d := range durations {
+		if _, known := p.durations[name]; !known {
+			p.durations[name] = d
+		}
+	}
+}
+
+// orderStages sorts names into the order in which the stages should start.
+func (p *Pipeline) orderStages(names []string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var remaining map[string]time.Duration
+	if p.config.Scheduling == ScheduleCriticalPath {
+		remaining = p.remainingPathLocked()
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if remaining[a] != remaining[b] {
+			return remaining[a] > remaining[b]
+		}
+		if pa, pb := stagePriority(p.stages[a]), stagePriority(p.stages[b]); pa != pb {
+			return pa > pb
+		}
+		return a < b
+	})
+	return names
+}
+
+// remainingPathLocked returns, for every stage, the estimated duration of the
+// longest chain of stages that starts with it. Stages without a recorded
+// duration are estimated at the mean of the recorded ones.
+func (p *Pipeline) remainingPathLocked() map[string]time.Duration {
+	estimate := defaultStageEstimate
+	if len(p.durations) > 0 {
+		var total time.Duration
+		for _, d := range p.durations {
+			total += d
+		}
+		estimate = total / time.Duration(len(p.durations))
+	}
+
+	dependents := make(map[string][]string, len(p.stages))
+	for name := range p.stages {
+		for _, dep := range p.deps(name) {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	remaining := make(map[string]time.Duration, len(p.stages))
+	var visit func(name string) time.Duration
+	visit = func(name string) time.Duration {
+		if d, ok := remaining[name]; ok {
+			return d
+		}
+		var longest time.Duration
+		for _, dependent := range dependents[name] {
+			if d := visit(dependent); d > longest {
+				longest = d
+			}
+		}
+		own, ok := p.durations[name]
+		if !ok {
+			own = estimate
+		}
+		remaining[name] = own + longest
+		return remaining[name]
+	}
+	for name := range p.stages {
+		visit(name)
+	}
+	return remaining
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrderStages(t *testing.T) {
+	tests := []struct {
+		name       string
+		scheduling SchedulingPolicy
+		durations  map[string]time.Duration
+		want       []string
+	}{
+		{"priority", SchedulePriority, nil, []string{"urgent", "long", "short"}},
+		{"critical path without history", ScheduleCriticalPath, nil, []string{"long", "urgent", "short"}},
+		{
+			"critical path with history",
+			ScheduleCriticalPath,
+			map[string]time.Duration{"urgent": time.Millisecond, "long": time.Millisecond, "long_next": time.Millisecond, "short": time.Minute},
+			[]string{"short", "long", "urgent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urgent := newTestStage("urgent", nil, sleepStage("urgent", 0))
+			urgent.SetPriority(5)
+			p := newTestPipeline(t, PipelineConfig{Scheduling: tt.scheduling},
+				urgent,
+				newTestStage("long", nil, sleepStage("long", 0)),
+				newTestStage("long_next", []string{"long"}, sleepStage("long_next", 0)),
+				newTestStage("short", nil, sleepStage("short", 0)),
+			)
+			p.SetStageDurations(tt.durations)
+
+			if got := p.orderStages([]string{"short", "long", "urgent"}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCriticalPathLoadsDurationsOfEarlierRuns(t *testing.T) {
+	store := NewFileCheckpointStore(t.TempDir())
+	newPipeline := func() *Pipeline {
+		p := newTestPipeline(t, PipelineConfig{Scheduling: ScheduleCriticalPath},
+			newTestStage("slow", nil, sleepStage("slow", 30*time.Millisecond)),
+			newTestStage("fast", nil, sleepStage("fast", 0)),
+		)
+		p.SetCheckpointStore(store)
+		return p
+	}
+
+	if err := newPipeline().Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	// A new pipeline on the same store, as in a new process, knows the
+	// durations of the first run once it executes.
+	p := newPipeline()
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	p.mu.RLock()
+	slow, fast := p.durations["slow"], p.durations["fast"]
+	p.mu.RUnlock()
+	if slow <= fast {
+		t.Errorf("durations are slow %s and fast %s", slow, fast)
+	}
+
+	fresh := newPipeline()
+	fresh.loadStageDurations()
+	if got := fresh.orderStages([]string{"fast", "slow"}); !reflect.DeepEqual(got, []string{"slow", "fast"}) {
+		t.Errorf("order is %v, want slow first", got)
+	}
+}
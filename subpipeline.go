@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+type pipelineInputKey struct{}
+
+type subPipelineStage interface {
+	Child() *Pipeline
+}
+
+// PipelineStage runs a whole pipeline, the child, as a single stage of a
+// parent pipeline. The input of the stage is passed to the child stages that
+// have no dependencies. The output is the output of the child stage that no
+// other child stage depends on, or Inputs by stage name if there are several.
+//
+// The child runs under the context of the stage attempt, so the parent's
+// timeouts, Stop, Pause and CancelStage reach the child stages. The child's
+// stage results appear as SubStages in the parent's status output.
+type PipelineStage struct {
+	BaseStage
+	child *Pipeline
+}
+
+func NewPipelineStage(name string, deps []string, child *Pipeline) *PipelineStage {
+	return &PipelineStage{
+		BaseStage: *NewBaseStage(name, deps),
+		child:     child,
+	}
+}
+
+func (s *PipelineStage) Child() *Pipeline { return s.child }
+
+func (s *PipelineStage) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+	child := s.child
+	if err := child.Validate(); err != nil {
+		return nil, Permanent(fmt.Errorf("sub-pipeline %s: %w", s.Name(), err))
+	}
+
+	// A retry carries on from the failed stages and an interrupted run from
+	// the pending ones. Only a finished child starts over.
+	switch {
+	case child.hasFailures():
+		child.RestartFailedStages()
+	case child.isFinished():
+		if err := child.Reset(); err != nil {
+			return nil, Permanent(fmt.Errorf("sub-pipeline %s: %w", s.Name(), err))
+		}
+	}
+
+	// Without a tracer of its own, the child traces into the parent's trace.
+	// The parent's tracer is shared as it is: SetTracer would replace its
+	// onError while the parent's spans use it.
+	if span := SpanFromContext(ctx); span != nil {
+		child.mu.Lock()
+		if child.tracer == nil {
+			child.tracer = span.tracer
+		}
+		child.mu.Unlock()
+	}
+
+	ctx = context.WithValue(ctx, pipelineInputKey{}, input)
+	if err := child.ExecuteContext(ctx); err != nil {
+		return nil, fmt.Errorf("sub-pipeline %s: %w", s.Name(), err)
+	}
+	if child.hasFailures() {
+		return nil, fmt.Errorf("sub-pipeline %s has failed stages", s.Name())
+	}
+	return child.finalOutput(), nil
+}
+
+// isFinished reports whether no stage is left to run.
+func (p *Pipeline) isFinished() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, result := range p.results {
+		if result.Status == StatusPending {
+			return false
+		}
+	}
+	return true
+}
+
+// finalOutput returns the output of the stage that no other stage depends on,
+// or Inputs with the outputs of all such stages if there are several.
+func (p *Pipeline) finalOutput() interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	isDependency := make(map[string]bool, len(p.stages))
+	for name := range p.stages {
+		for _, dep := range p.deps(name) {
+			isDependency[dep] = true
+		}
+	}
+
+	outputs := make(Inputs)
+	for name, result := range p.results {
+		if !isDependency[name] {
+			outputs[name] = result.Output
+		}
+	}
+	if len(outputs) == 1 {
+		for _, output := range outputs {
+			return output
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelineStageRollsUpChildResults(t *testing.T) {
+	var failures atomic.Int32
+	child := newTestPipeline(t, PipelineConfig{},
+		newTestStage("double", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			return input.(int) * 2, nil
+		}),
+		newTestStage("flaky", []string{"double"}, func(ctx context.Context, input interface{}) (interface{}, error) {
+			if failures.Add(1) == 1 {
+				return nil, errors.New("boom")
+			}
+			return input.(int) + 1, nil
+		}),
+	)
+	stage := NewPipelineStage("child", []string{"source"}, child)
+	stage.SetMaxRetries(1).SetRetryDelay(time.Millisecond)
+	p := newTestPipeline(t, PipelineConfig{},
+		newTestStage("source", nil, func(ctx context.Context, input interface{}) (interface{}, error) { return 20, nil }),
+		stage,
+	)
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	result, _ := p.GetStageResult("child")
+	if result.Output != 41 {
+		t.Errorf("output is %v, want 41", result.Output)
+	}
+	sub := p.snapshotResults()["child"].SubStages
+	if sub["double"].Attempts != 1 || sub["flaky"].Attempts != 1 || sub["flaky"].Status != StatusCompleted {
+		t.Errorf("retry of the stage ran more than the failed child stage: %+v %+v", sub["double"], sub["flaky"])
+	}
+}
+
+func TestPipelineStagePropagatesCancellation(t *testing.T) {
+	started := make(chan struct{})
+	child := newTestPipeline(t, PipelineConfig{},
+		newTestStage("wait", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, context.Cause(ctx)
+		}),
+	)
+	p := newTestPipeline(t, PipelineConfig{}, NewPipelineStage("child", nil, child))
+
+	go func() {
+		<-started
+		p.CancelStage("child", errors.New("not needed"))
+	}()
+	p.Execute()
+	assertStatuses(t, p, map[string]StageStatus{"child": StatusCancelled})
+	assertStatuses(t, child, map[string]StageStatus{"wait": StatusCancelled})
+}
+
+type failingExporter struct{}
+
+func (failingExporter) ExportSpan(span *Span) error { return errors.New("collector unavailable") }
+
+// TestPipelineStagesShareParentTracer is meant to run with -race: child
+// pipelines start tracing into the parent's tracer while the parent's spans
+// report export errors through it.
+func TestPipelineStagesShareParentTracer(t *testing.T) {
+	p := newTestPipeline(t, PipelineConfig{})
+	for i := 0; i < 20; i++ {
+		child := newTestPipeline(t, PipelineConfig{}, newTestStage("inner", nil, sleepStage("inner", 0)))
+		p.AddStage(NewPipelineStage(fmt.Sprintf("child-%d", i), nil, child))
+	}
+	p.SetTracer(NewTracer(failingExporter{}))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Span is one timed operation of a trace: a pipeline run, a stage, an
+// attempt, or a child span started by a stage with StartSpan. All methods
+// are safe to call on a nil span, which is what StartSpan returns when the
+// pipeline has no tracer.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	Err        error
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Attributes[key] = value
+}
+
+// Finish ends the span with the outcome err and exports it. Only the first
+// call has an effect.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.Err = err
+	s.mu.Unlock()
+
+	if err := s.tracer.exporter.ExportSpan(s); err != nil {
+		s.tracer.onError(err)
+	}
+}
+
+type SpanExporter interface {
+	ExportSpan(span *Span) error
+}
+
+type Tracer struct {
+	exporter SpanExporter
+	onError  func(err error)
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter, onError: func(error) {}}
+}
+
+type spanKey struct{}
+
+// Start begins a span that is a child of the span in ctx, if any, and returns
+// a context carrying the new span. A nil tracer starts no span.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		SpanID:     newTraceID(8),
+		Name:       name,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = newTraceID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the span in ctx with the same tracer. Stages
+// use it to trace their own work; without a tracer it returns a nil span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+func newTraceID(bytes int) string {
+	id := make([]byte, bytes)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("generating trace ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpan(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns the finished spans in the order they ended.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
+
+// OTLPFileExporter appends every finished span to a file as one line of
+// OTLP/JSON, the format read by the OpenTelemetry Collector file receiver.
+type OTLPFileExporter struct {
+	mu          sync.Mutex
+	file        *os.File
+	serviceName string
+}
+
+func NewOTLPFileExporter(path, serviceName string) (*OTLPFileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPFileExporter{file: file, serviceName: serviceName}, nil
+}
+
+func (e *OTLPFileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.file.Close()
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	list := make([]otlpAttribute, 0, len(attributes))
+	for _, key := range sortedKeys(attributes) {
+		var value map[string]interface{}
+		switch v := attributes[key].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, otlpAttribute{Key: key, Value: value})
+	}
+	return list
+}
+
+func (e *OTLPFileExporter) ExportSpan(span *Span) error {
+	span.mu.Lock()
+	otlpSpan := map[string]interface{}{
+		"traceId":           span.TraceID,
+		"spanId":            span.SpanID,
+		"name":              span.Name,
+		"kind":              1, // SPAN_KIND_INTERNAL
+		"startTimeUnixNano": strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		"attributes":        otlpAttributes(span.Attributes),
+	}
+	if span.ParentID != "" {
+		otlpSpan["parentSpanId"] = span.ParentID
+	}
+	if span.Err != nil {
+		otlpSpan["status"] = map[string]interface{}{"code": 2, "message": span.Err.Error()} // STATUS_CODE_ERROR
+	}
+	span.mu.Unlock()
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "pipeline"},
+				"spans": []interface{}{otlpSpan},
+			}},
+		}},
+	}
+
+	line, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+// SetTracer makes the pipeline trace every run, stage and attempt with t.
+// The span of the running attempt is available to stages through their ctx.
+func (p *Pipeline) SetTracer(t *Tracer) *Pipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tracer = t
+	if t != nil {
+		t.onError = func(err error) {
+			p.log().Warn("Failed to export span", "error", err)
+		}
+	}
+	return p
+}
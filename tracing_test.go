@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTracerRecordsRunStageAndAttemptSpans(t *testing.T) {
+	var calls atomic.Int32
+	stage := newTestStage("flaky", nil, func(ctx context.Context, input interface{}) (interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("boom")
+		}
+		_, span := StartSpan(ctx, "fetch")
+		span.SetAttribute("rows", 3)
+		span.Finish(nil)
+		return nil, nil
+	})
+	stage.SetMaxRetries(1)
+	exporter := NewInMemoryExporter()
+	p := newTestPipeline(t, PipelineConfig{}, stage)
+	p.SetTracer(NewTracer(exporter))
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	byName := make(map[string][]*Span)
+	for _, span := range exporter.Spans() {
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+	if len(byName["pipeline.run"]) != 1 || len(byName["pipeline.stage"]) != 1 ||
+		len(byName["pipeline.attempt"]) != 2 || len(byName["fetch"]) != 1 {
+		t.Fatalf("got spans %v, want one run, one stage, two attempts and one fetch", byName)
+	}
+	run, stageSpan, fetch := byName["pipeline.run"][0], byName["pipeline.stage"][0], byName["fetch"][0]
+	failed, retried := byName["pipeline.attempt"][0], byName["pipeline.attempt"][1]
+
+	for _, span := range exporter.Spans() {
+		if span.TraceID != run.TraceID {
+			t.Errorf("span %s is in trace %s, want %s", span.Name, span.TraceID, run.TraceID)
+		}
+	}
+	parents := []struct {
+		span   *Span
+		parent *Span
+	}{
+		{stageSpan, run},
+		{failed, stageSpan},
+		{retried, stageSpan},
+		{fetch, retried},
+	}
+	for _, tt := range parents {
+		if tt.span.ParentID != tt.parent.SpanID {
+			t.Errorf("span %s has parent %s, want %s (%s)", tt.span.Name, tt.span.ParentID, tt.parent.SpanID, tt.parent.Name)
+		}
+	}
+	if run.ParentID != "" {
+		t.Errorf("run span has parent %s", run.ParentID)
+	}
+
+	if failed.Attributes["attempt"] != 1 || retried.Attributes["attempt"] != 2 {
+		t.Errorf("attempt spans have attempts %v and %v, want 1 and 2", failed.Attributes["attempt"], retried.Attributes["attempt"])
+	}
+	if failed.Err == nil || failed.Attributes["retry.delay"] != "1ms" {
+		t.Errorf("failed attempt has error %v and retry.delay %v, want an error and 1ms", failed.Err, failed.Attributes["retry.delay"])
+	}
+	if _, ok := retried.Attributes["retry.delay"]; ok || retried.Err != nil {
+		t.Errorf("successful attempt has retry.delay %v and error %v", retried.Attributes["retry.delay"], retried.Err)
+	}
+	if fetch.Attributes["rows"] != 3 {
+		t.Errorf("fetch span has rows %v, want 3", fetch.Attributes["rows"])
+	}
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "fetch")
+	span.SetAttribute("rows", 3)
+	span.Finish(nil)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("StartSpan without a tracer returned span %v", span)
+	}
+}
+
+func TestOTLPFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewOTLPFileExporter(path, "etl")
+	if err != nil {
+		t.Fatalf("NewOTLPFileExporter: %v", err)
+	}
+	tracer := NewTracer(exporter)
+	ctx, parent := tracer.Start(context.Background(), "pipeline.run")
+	_, span := tracer.Start(ctx, "pipeline.stage")
+	span.SetAttribute("stage.name", "extract")
+	span.SetAttribute("attempt", 2)
+	span.Finish(errors.New("boom"))
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string          `json:"traceId"`
+					SpanID            string          `json:"spanId"`
+					ParentSpanID      string          `json:"parentSpanId"`
+					Name              string          `json:"name"`
+					StartTimeUnixNano string          `json:"startTimeUnixNano"`
+					EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+					Attributes        []otlpAttribute `json:"attributes"`
+					Status            struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+
+	resource := line.ResourceSpans[0]
+	if got := resource.Resource.Attributes; len(got) != 1 || got[0].Key != "service.name" || got[0].Value["stringValue"] != "etl" {
+		t.Errorf("resource attributes are %v, want service.name etl", got)
+	}
+	got := resource.ScopeSpans[0].Spans[0]
+	if got.TraceID != parent.TraceID || got.SpanID != span.SpanID || got.ParentSpanID != parent.SpanID || got.Name != "pipeline.stage" {
+		t.Errorf("span is %+v, want %s/%s with parent %s", got, span.TraceID, span.SpanID, parent.SpanID)
+	}
+	if got.StartTimeUnixNano == "" || got.EndTimeUnixNano < got.StartTimeUnixNano {
+		t.Errorf("span runs from %s to %s", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	if got.Status.Code != 2 || got.Status.Message != "boom" {
+		t.Errorf("status is %+v, want code 2 with message boom", got.Status)
+	}
+	attributes := make(map[string]map[string]interface{})
+	for _, attribute := range got.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	if attributes["attempt"]["intValue"] != "2" || attributes["stage.name"]["stringValue"] != "extract" {
+		t.Errorf("attributes are %v", got.Attributes)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+type TypedStage[In, Out any] interface {
+	Name() string
+	Execute(ctx context.Context, input In) (Out, error)
+	Dependencies() []string
+	MaxRetries() int
+	RetryDelay() time.Duration
+	Timeout() time.Duration
+}
+
+type typedStage[In, Out any] struct {
+	stage TypedStage[In, Out]
+}
+
+// AdaptStage wraps a TypedStage so it can be added to a Pipeline. The input
+// and output types are checked against connected typed stages by Validate.
+func AdaptStage[In, Out any](stage TypedStage[In, Out]) Stage {
+	return &typedStage[In, Out]{stage: stage}
+}
+
+func (s *typedStage[In, Out]) Name() string              { return s.stage.Name() }
+func (s *typedStage[In, Out]) Dependencies() []string    { return s.stage.Dependencies() }
+func (s *typedStage[In, Out]) MaxRetries() int           { return s.stage.MaxRetries() }
+func (s *typedStage[In, Out]) RetryDelay() time.Duration { return s.stage.RetryDelay() }
+func (s *typedStage[In, Out]) Timeout() time.Duration    { return s.stage.Timeout() }
+
+func (s *typedStage[In, Out]) Execute(ctx context.Context, input interface{}) (interface{}, error) {
+	var in In
+	if input != nil {
+		typed, ok := input.(In)
+		if !ok {
+			return nil, Permanent(fmt.Errorf("stage %s expects input of type %s, got %T", s.Name(), typeOf[In](), input))
+		}
+		in = typed
+	}
+
+	out, err := s.stage.Execute(ctx, in)
+	return out, err
+}
+
+func (s *typedStage[In, Out]) stageTypes() (reflect.Type, reflect.Type) {
+	return typeOf[In](), typeOf[Out]()
+}
+
+func (s *typedStage[In, Out]) decodeOutput(data []byte) (interface{}, error) {
+	var out Out
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
+
+func (s *typedStage[In, Out]) unwrapStage() interface{} {
+	return s.stage
+}
+
+type typedStageInfo interface {
+	stageTypes() (in reflect.Type, out reflect.Type)
+}
+
+type stageUnwrapper interface {
+	unwrapStage() interface{}
+}
+
+// stageAs reports whether stage, or the stage it adapts, implements T. It is
+// used to look up optional stage capabilities through adapters.
+func stageAs[T any](stage interface{}) (T, bool) {
+	for stage != nil {
+		if capability, ok := stage.(T); ok {
+			return capability, true
+		}
+		wrapper, ok := stage.(stageUnwrapper)
+		if !ok {
+			break
+		}
+		stage = wrapper.unwrapStage()
+	}
+	var zero T
+	return zero, false
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// InputAs returns the output of the named dependency as a T.
+func InputAs[T any](inputs Inputs, name string) (T, error) {
+	var zero T
+	output, ok := inputs.Get(name)
+	if !ok {
+		return zero, fmt.Errorf("no input from stage %s", name)
+	}
+	typed, ok := output.(T)
+	if !ok {
+		return zero, fmt.Errorf("input from stage %s has type %T, want %s", name, output, typeOf[T]())
+	}
+	return typed, nil
+}
+
+// acceptsType reports whether a value of type out passes the type assertion
+// to in done by typed stages on their input. Unlike assignability, it rejects
+// a named type standing in for its underlying type, or the reverse.
+func acceptsType(in, out reflect.Type) bool {
+	if in.Kind() == reflect.Interface {
+		return out.Implements(in)
+	}
+	return out == in
+}
+
+func (p *Pipeline) validateStageTypes() error {
+	inputsType := typeOf[Inputs]()
+
+	for _, stage := range p.stages {
+		consumer, ok := stage.(typedStageInfo)
+		if !ok {
+			continue
+		}
+		in, _ := consumer.stageTypes()
+
+		deps := p.deps(stage.Name())
+		switch len(deps) {
+		case 0:
+		case 1:
+			producer, ok := p.stages[deps[0]].(typedStageInfo)
+			if !ok {
+				continue
+			}
+			_, out := producer.stageTypes()
+			if !acceptsType(in, out) {
+				return fmt.Errorf("stage %s produces %s but dependent stage %s expects %s", deps[0], out, stage.Name(), in)
+			}
+		default:
+			if !acceptsType(in, inputsType) {
+				return fmt.Errorf("stage %s has %d dependencies and must accept Inputs, not %s", stage.Name(), len(deps), in)
+			}
+		}
+	}
+	return nil
+}
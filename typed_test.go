@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Names []string
+
+type typedTestStage[In, Out any] struct {
+	*BaseStage
+	fn func(in In) Out
+}
+
+func (s *typedTestStage[In, Out]) Execute(ctx context.Context, in In) (Out, error) {
+	return s.fn(in), nil
+}
+
+func newTypedTestStage[In, Out any](name string, deps []string, fn func(in In) Out) Stage {
+	return AdaptStage[In, Out](&typedTestStage[In, Out]{
+		BaseStage: NewBaseStage(name, deps).SetMaxRetries(0).SetRetryDelay(time.Millisecond),
+		fn:        fn,
+	})
+}
+
+func TestValidateStageTypesMatchesRuntimeCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		consumer Stage
+		wantErr  string
+	}{
+		{"same type", newTypedTestStage("consumer", []string{"producer"}, func(in []string) int { return len(in) }), ""},
+		{"interface", newTypedTestStage("consumer", []string{"producer"}, func(in fmt.Stringer) int { return 0 }), "expects fmt.Stringer"},
+		{"empty interface", newTypedTestStage("consumer", []string{"producer"}, func(in interface{}) int { return 0 }), ""},
+		{"named type", newTypedTestStage("consumer", []string{"producer"}, func(in Names) int { return len(in) }), "expects main.Names"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPipeline(t, PipelineConfig{},
+				newTypedTestStage("producer", nil, func(struct{}) []string { return []string{"a", "b"} }),
+				tt.consumer,
+			)
+
+			err := p.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				if err := p.Execute(); err != nil {
+					t.Errorf("Execute of a valid pipeline: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}